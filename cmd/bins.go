@@ -0,0 +1,104 @@
+package main
+
+// ==== http-toolkit: bins.go =========================================================================================
+// Handlers for named request bins, every request sent to /bins/{id}/* is captured into that bin's own history
+// ====================================================================================================================
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/benc-uk/http-toolkit/pkg/history"
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+	"github.com/go-chi/chi/v5"
+)
+
+// Limit on how many bins can exist at once, to stop the server eating all the memory
+const maxBins = 100
+
+// Holds all request bins, will be nil when history is disabled
+var requestBins *history.Bins
+
+// Details of a bin returned by the /bins API
+type BinInfo struct {
+	ID       string                    `json:"id"`
+	Name     string                    `json:"name,omitempty"`
+	Created  string                    `json:"created"`
+	URL      string                    `json:"url"`
+	Count    int                       `json:"count"`
+	Requests []httputil.RequestDetails `json:"requests,omitempty"`
+}
+
+func binCreate(w http.ResponseWriter, r *http.Request) {
+	bin, err := requestBins.Create(r.URL.Query().Get("name"))
+	if errors.Is(err, history.ErrTooManyBins) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte("Maximum number of bins reached, delete some first"))
+
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newBinInfo(r, bin))
+}
+
+func binList(w http.ResponseWriter, r *http.Request) {
+	bins := []BinInfo{}
+	for _, bin := range requestBins.List() {
+		bins = append(bins, newBinInfo(r, bin))
+	}
+
+	writeJSON(w, http.StatusOK, bins)
+}
+
+func binGet(w http.ResponseWriter, r *http.Request) {
+	bin, found := requestBins.Get(chi.URLParam(r, "id"))
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Bin not found"))
+
+		return
+	}
+
+	info := newBinInfo(r, bin)
+	info.Requests = formatAll(r, bin.Requests.List(history.NewFilter(r.URL.Query())))
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+func binDelete(w http.ResponseWriter, r *http.Request) {
+	if !requestBins.Delete(chi.URLParam(r, "id")) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Bin not found"))
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// binCapture inspects any request sent to a bin, storing it in the bin and echoing it back
+func binCapture(w http.ResponseWriter, r *http.Request) {
+	bin, found := requestBins.Get(chi.URLParam(r, "id"))
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Bin not found"))
+
+		return
+	}
+
+	reqDetails := inspectRequest(r)
+	bin.Requests.Add(reqDetails)
+
+	writeJSON(w, http.StatusOK, reqDetails.Format(outputVersion(r)))
+}
+
+func newBinInfo(r *http.Request, bin *history.Bin) BinInfo {
+	return BinInfo{
+		ID:      bin.ID,
+		Name:    bin.Name,
+		Created: bin.Created.Format(time.RFC3339),
+		URL:     baseURL(r) + cfg.routePrefix + "bins/" + bin.ID + "/",
+		Count:   bin.Requests.Len(),
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+// ==== http-toolkit: bytes.go ========================================================================================
+// Handlers which generate binary payloads, for testing downloads, resumption & range requests
+// ====================================================================================================================
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Largest payload which can be generated, the bytes are generated as they are sent so this isn't held in memory
+const maxPayloadBytes = 100 * 1024 * 1024
+
+// Default chunk size for /stream-bytes
+const defaultChunkSize = 10 * 1024
+
+// randomBytes sends n random bytes, passing the same seed always gives the same bytes
+// The seed used is returned in the X-Seed header, so any payload can be reproduced
+func randomBytes(w http.ResponseWriter, r *http.Request) {
+	n, ok := payloadSize(w, r)
+	if !ok {
+		return
+	}
+
+	random, ok := seededRandom(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = io.CopyN(w, random, int64(n))
+}
+
+// streamBytes sends n random bytes in chunks, flushing each chunk so the response uses chunked transfer encoding
+func streamBytes(w http.ResponseWriter, r *http.Request) {
+	n, ok := payloadSize(w, r)
+	if !ok {
+		return
+	}
+
+	chunkSize, ok := queryInt(w, r, "chunk_size", defaultChunkSize, maxStreamBytes)
+	if !ok {
+		return
+	}
+
+	if chunkSize == 0 {
+		badRequest(w, "Invalid chunk_size value, must be greater than 0")
+		return
+	}
+
+	random, ok := seededRandom(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	rc := http.NewResponseController(w)
+
+	for sent := 0; sent < n; sent += chunkSize {
+		if _, err := io.CopyN(w, random, int64(min(chunkSize, n-sent))); err != nil {
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// rangeBytes sends n bytes of predictable content, honouring Range & If-Range headers
+// Multiple ranges get a multipart/byteranges response, the ETag only changes with n so resumed downloads match up
+func rangeBytes(w http.ResponseWriter, r *http.Request) {
+	n, ok := payloadSize(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", fmt.Sprintf(`"range-%d"`, n))
+
+	http.ServeContent(w, r, "", time.Time{}, &alphabetContent{size: int64(n)})
+}
+
+// Get the size of a payload from the n path parameter
+func payloadSize(w http.ResponseWriter, r *http.Request) (int, bool) {
+	n, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if err != nil || n < 0 || n > maxPayloadBytes {
+		badRequest(w, fmt.Sprintf("Invalid n value, must be 0-%d", maxPayloadBytes))
+		return 0, false
+	}
+
+	return n, true
+}
+
+// Get a random source from the seed query parameter, or a random seed if there isn't one
+func seededRandom(w http.ResponseWriter, r *http.Request) (*rand.Rand, bool) {
+	//nolint:gosec
+	seed := rand.Int63()
+
+	if value := r.URL.Query().Get("seed"); value != "" {
+		var err error
+
+		seed, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			badRequest(w, "Invalid seed value")
+			return nil, false
+		}
+	}
+
+	w.Header().Set("X-Seed", strconv.FormatInt(seed, 10))
+
+	//nolint:gosec
+	return rand.New(rand.NewSource(seed)), true
+}
+
+// alphabetContent is a ReadSeeker over size bytes of the alphabet repeated, generated as it's read
+type alphabetContent struct {
+	size   int64
+	offset int64
+}
+
+func (a *alphabetContent) Read(p []byte) (int, error) {
+	if a.offset >= a.size {
+		return 0, io.EOF
+	}
+
+	n := int(min(int64(len(p)), a.size-a.offset))
+	for i := range n {
+		p[i] = byte('a' + (a.offset+int64(i))%26)
+	}
+
+	a.offset += int64(n)
+
+	return n, nil
+}
+
+func (a *alphabetContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += a.offset
+	case io.SeekEnd:
+		offset += a.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+
+	a.offset = offset
+
+	return offset, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+// ==== http-toolkit: chaos.go ========================================================================================
+// Admin API handlers to view & change the chaos settings at runtime
+// ====================================================================================================================
+
+import (
+	"net/http"
+
+	"github.com/benc-uk/http-toolkit/pkg/chaos"
+)
+
+// Injects chaos into all requests, apart from the admin API, when enabled
+var chaosEngine *chaos.Chaos
+
+// chaosGet returns the current chaos settings
+func chaosGet(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, chaosEngine.Settings())
+}
+
+// chaosUpdate changes the chaos settings given as query parameters, any settings not given are left as they are
+func chaosUpdate(w http.ResponseWriter, r *http.Request) {
+	values := map[string]string{}
+	for name := range r.URL.Query() {
+		values[name] = r.URL.Query().Get(name)
+	}
+
+	if err := chaosEngine.Update(values); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Invalid options: " + err.Error()))
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, chaosEngine.Settings())
+}
+
+// chaosReset turns off all chaos
+func chaosReset(w http.ResponseWriter, r *http.Request) {
+	chaosEngine.Reset()
+	writeJSON(w, http.StatusOK, chaosEngine.Settings())
+}
@@ -6,11 +6,20 @@ package main
 // ====================================================================================================================
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
 	"strings"
+
+	"github.com/benc-uk/http-toolkit/pkg/chaos"
+	"github.com/benc-uk/http-toolkit/pkg/compress"
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+	"github.com/benc-uk/http-toolkit/pkg/redact"
 )
 
 type Config struct {
@@ -26,6 +35,43 @@ type Config struct {
 	useTLS            bool
 	spaPath           string
 	staticPath        string
+	historySize       int
+	captureFile       string
+	captureMaxSize    int
+	inspectVersion    int
+	clientCAPath      string
+	clientAuth        string
+	filePreviewSize   int
+	maxBodySize       int
+	redact            bool
+	redactHeaders     string
+	redactJSONPaths   string
+	redactPatterns    string
+	logFormat         string
+	logLevel          string
+	metrics           bool
+	tracing           string
+	drainTimeout      int
+	preStopDelay      int
+	startupDelay      int
+	chaosErrorRate    string
+	chaosErrorCodes   string
+	chaosLatency      string
+	chaosResetRate    string
+	chaosTruncateRate string
+	maxDelay          int
+	compression       bool
+	compressMinSize   int
+	compressTypes     string
+
+	// Messages from loading the config, held until logging is set up, see flushLogs
+	logs []configLog
+}
+
+type configLog struct {
+	level slog.Level
+	msg   string
+	args  []any
 }
 
 // NewConfig creates a new AppConfig with all default values
@@ -43,6 +89,34 @@ func NewConfig() Config {
 		useTLS:            false,
 		spaPath:           "",
 		staticPath:        "",
+		historySize:       100,
+		captureFile:       "",
+		captureMaxSize:    10,
+		inspectVersion:    httputil.FormatV1,
+		clientCAPath:      "",
+		clientAuth:        "none",
+		filePreviewSize:   0,
+		maxBodySize:       1024,
+		redact:            false,
+		redactHeaders:     "",
+		redactJSONPaths:   "",
+		redactPatterns:    "",
+		logFormat:         "text",
+		logLevel:          "info",
+		metrics:           true,
+		tracing:           "none",
+		drainTimeout:      30,
+		preStopDelay:      0,
+		startupDelay:      0,
+		chaosErrorRate:    "",
+		chaosErrorCodes:   "",
+		chaosLatency:      "",
+		chaosResetRate:    "",
+		chaosTruncateRate: "",
+		maxDelay:          60,
+		compression:       true,
+		compressMinSize:   1024,
+		compressTypes:     "",
 	}
 }
 
@@ -59,10 +133,62 @@ func (cfg *Config) loadFlags() {
 	flag.StringVar(&cfg.basicAuthPassword, "basic-auth-password", cfg.basicAuthPassword, "Basic auth password")
 	flag.StringVar(&cfg.jwtSignKey, "jwt-sign-key", cfg.jwtSignKey, "Signing key for JWT")
 	flag.StringVar(&cfg.certPath, "cert-path", cfg.certPath, "Path to directory with TLS cert & key files")
+	flag.StringVar(&cfg.clientCAPath, "client-ca-path", cfg.clientCAPath,
+		"PEM file of CA certs used to verify client certificates when using mTLS")
+	flag.StringVar(&cfg.clientAuth, "client-auth", cfg.clientAuth,
+		"Client certificate mode for mTLS, one of: none, request, require, verify-if-given, verify")
 	flag.StringVar(&cfg.spaPath, "spa-path", cfg.spaPath,
 		"Path to SPA files to serve, default is none and don't serve SPA")
 	flag.StringVar(&cfg.staticPath, "static-path", cfg.staticPath,
 		"Path to static files to serve, default is none and don't serve files")
+	flag.IntVar(&cfg.historySize, "history-size", cfg.historySize,
+		"Number of inspected requests to keep in history, set to 0 to disable")
+	flag.StringVar(&cfg.captureFile, "capture-file", cfg.captureFile,
+		"JSONL file to persist request history to, default is none and history is in memory only")
+	flag.IntVar(&cfg.captureMaxSize, "capture-max-size", cfg.captureMaxSize,
+		"Size in MB at which the capture file is rotated")
+	flag.IntVar(&cfg.inspectVersion, "inspect-version", cfg.inspectVersion,
+		"Default output format version for inspected requests, 2 includes all header & query values")
+	flag.IntVar(&cfg.filePreviewSize, "file-preview-size", cfg.filePreviewSize,
+		"Bytes of each uploaded file to include base64 encoded when inspecting forms, 0 disables previews")
+	flag.IntVar(&cfg.maxBodySize, "max-body-size", cfg.maxBodySize,
+		"Maximum size in KB of request bodies to inspect, larger bodies are truncated, 0 is no limit")
+	flag.StringVar(&cfg.logFormat, "log-format", cfg.logFormat, "Log output format, either text or json")
+	flag.StringVar(&cfg.logLevel, "log-level", cfg.logLevel, "Minimum log level, one of: debug, info, warn, error")
+	flag.BoolVar(&cfg.metrics, "metrics", cfg.metrics, "Expose Prometheus metrics on /metrics")
+	flag.IntVar(&cfg.drainTimeout, "drain-timeout", cfg.drainTimeout,
+		"Seconds to wait for in-flight requests to finish when shutting down")
+	flag.IntVar(&cfg.preStopDelay, "pre-stop-delay", cfg.preStopDelay,
+		"Seconds to keep serving after a shutdown signal with readiness failing, before draining starts")
+	flag.IntVar(&cfg.startupDelay, "startup-delay", cfg.startupDelay,
+		"Seconds after starting before the startup & readiness probes pass")
+	flag.IntVar(&cfg.maxDelay, "max-delay", cfg.maxDelay,
+		"Maximum delay in seconds for /delay & chaos latency, longer delays are capped, 0 is no limit")
+	flag.StringVar(&cfg.chaosErrorRate, "chaos-error-rate", cfg.chaosErrorRate,
+		"Percentage of requests to fail with an error status code")
+	flag.StringVar(&cfg.chaosErrorCodes, "chaos-error-codes", cfg.chaosErrorCodes,
+		"Comma separated list of status codes for chaos errors, one is picked at random, default is 500")
+	flag.StringVar(&cfg.chaosLatency, "chaos-latency", cfg.chaosLatency,
+		"Latency to add to requests e.g. fixed:200ms, uniform:100ms,1s, normal:200ms,50ms or exponential:200ms")
+	flag.StringVar(&cfg.chaosResetRate, "chaos-reset-rate", cfg.chaosResetRate,
+		"Percentage of requests to abruptly close the connection on, without a response")
+	flag.StringVar(&cfg.chaosTruncateRate, "chaos-truncate-rate", cfg.chaosTruncateRate,
+		"Percentage of responses to cut short")
+	flag.BoolVar(&cfg.compression, "compression", cfg.compression,
+		"Compress responses with gzip, brotli or zstd when the client accepts it")
+	flag.IntVar(&cfg.compressMinSize, "compress-min-size", cfg.compressMinSize,
+		"Minimum size in bytes of responses to compress")
+	flag.StringVar(&cfg.compressTypes, "compress-types", cfg.compressTypes,
+		"Comma separated list of content types to compress e.g. text/*,application/json, default is common text types")
+	flag.StringVar(&cfg.tracing, "tracing", cfg.tracing, "OpenTelemetry trace exporter, one of: none, otlp, stdout")
+	flag.BoolVar(&cfg.redact, "redact", cfg.redact,
+		"Redact auth headers, cookies & secret looking query params from logs, history & echoed requests")
+	flag.StringVar(&cfg.redactHeaders, "redact-headers", cfg.redactHeaders,
+		"Comma separated list of extra headers to redact, implies -redact")
+	flag.StringVar(&cfg.redactJSONPaths, "redact-json-paths", cfg.redactJSONPaths,
+		"Comma separated list of JSON body paths to redact e.g. user.password,cards.*.number, implies -redact")
+	flag.StringVar(&cfg.redactPatterns, "redact-patterns", cfg.redactPatterns,
+		"Space separated list of regular expressions, matches are redacted everywhere, implies -redact")
 
 	flag.Usage = func() {
 		fmt.Printf("http-toolkit %s - A simple HTTP toolkit for debugging and testing", version)
@@ -73,6 +199,9 @@ func (cfg *Config) loadFlags() {
 
 	flag.Parse()
 
+	// Lowercased like the TRACING env var, so the value can be compared as it is
+	cfg.tracing = strings.ToLower(strings.TrimSpace(cfg.tracing))
+
 	if *printVer {
 		fmt.Println(version)
 		os.Exit(0)
@@ -103,6 +232,11 @@ func (cfg *Config) loadEnv() {
 		cfg.inspectAll = false
 	}
 
+	metrics := strings.ToLower(os.Getenv("METRICS"))
+	if metrics == "false" || metrics == "0" {
+		cfg.metrics = false
+	}
+
 	routePrefix := os.Getenv("ROUTE_PREFIX")
 	if routePrefix != "" {
 		cfg.routePrefix = routePrefix
@@ -136,6 +270,16 @@ func (cfg *Config) loadEnv() {
 		cfg.certPath = certPath
 	}
 
+	clientCAPath := os.Getenv("CLIENT_CA_PATH")
+	if clientCAPath != "" {
+		cfg.clientCAPath = clientCAPath
+	}
+
+	clientAuth := strings.ToLower(os.Getenv("CLIENT_AUTH"))
+	if clientAuth != "" {
+		cfg.clientAuth = clientAuth
+	}
+
 	spaPath := os.Getenv("SPA_PATH")
 	if spaPath != "" {
 		cfg.spaPath = spaPath
@@ -146,24 +290,322 @@ func (cfg *Config) loadEnv() {
 		cfg.staticPath = staticPath
 	}
 
+	historySize := os.Getenv("HISTORY_SIZE")
+	if historySize != "" {
+		size, err := strconv.Atoi(historySize)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid HISTORY_SIZE value", "value", historySize)
+		} else {
+			cfg.historySize = size
+		}
+	}
+
+	captureFile := os.Getenv("CAPTURE_FILE")
+	if captureFile != "" {
+		cfg.captureFile = captureFile
+	}
+
+	captureMaxSize := os.Getenv("CAPTURE_MAX_SIZE")
+	if captureMaxSize != "" {
+		size, err := strconv.Atoi(captureMaxSize)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid CAPTURE_MAX_SIZE value", "value", captureMaxSize)
+		} else {
+			cfg.captureMaxSize = size
+		}
+	}
+
+	inspectVersion := os.Getenv("INSPECT_VERSION")
+	if inspectVersion != "" {
+		ver, err := strconv.Atoi(inspectVersion)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid INSPECT_VERSION value", "value", inspectVersion)
+		} else {
+			cfg.inspectVersion = ver
+		}
+	}
+
+	filePreviewSize := os.Getenv("FILE_PREVIEW_SIZE")
+	if filePreviewSize != "" {
+		size, err := strconv.Atoi(filePreviewSize)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid FILE_PREVIEW_SIZE value", "value", filePreviewSize)
+		} else {
+			cfg.filePreviewSize = size
+		}
+	}
+
+	maxBodySize := os.Getenv("MAX_BODY_SIZE")
+	if maxBodySize != "" {
+		size, err := strconv.Atoi(maxBodySize)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid MAX_BODY_SIZE value", "value", maxBodySize)
+		} else {
+			cfg.maxBodySize = size
+		}
+	}
+
+	drainTimeout := os.Getenv("DRAIN_TIMEOUT")
+	if drainTimeout != "" {
+		secs, err := strconv.Atoi(drainTimeout)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid DRAIN_TIMEOUT value", "value", drainTimeout)
+		} else {
+			cfg.drainTimeout = secs
+		}
+	}
+
+	preStopDelay := os.Getenv("PRE_STOP_DELAY")
+	if preStopDelay != "" {
+		secs, err := strconv.Atoi(preStopDelay)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid PRE_STOP_DELAY value", "value", preStopDelay)
+		} else {
+			cfg.preStopDelay = secs
+		}
+	}
+
+	startupDelay := os.Getenv("STARTUP_DELAY")
+	if startupDelay != "" {
+		secs, err := strconv.Atoi(startupDelay)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid STARTUP_DELAY value", "value", startupDelay)
+		} else {
+			cfg.startupDelay = secs
+		}
+	}
+
+	maxDelay := os.Getenv("MAX_DELAY")
+	if maxDelay != "" {
+		secs, err := strconv.Atoi(maxDelay)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid MAX_DELAY value", "value", maxDelay)
+		} else {
+			cfg.maxDelay = secs
+		}
+	}
+
+	chaosErrorRate := os.Getenv("CHAOS_ERROR_RATE")
+	if chaosErrorRate != "" {
+		cfg.chaosErrorRate = chaosErrorRate
+	}
+
+	chaosErrorCodes := os.Getenv("CHAOS_ERROR_CODES")
+	if chaosErrorCodes != "" {
+		cfg.chaosErrorCodes = chaosErrorCodes
+	}
+
+	chaosLatency := os.Getenv("CHAOS_LATENCY")
+	if chaosLatency != "" {
+		cfg.chaosLatency = chaosLatency
+	}
+
+	chaosResetRate := os.Getenv("CHAOS_RESET_RATE")
+	if chaosResetRate != "" {
+		cfg.chaosResetRate = chaosResetRate
+	}
+
+	chaosTruncateRate := os.Getenv("CHAOS_TRUNCATE_RATE")
+	if chaosTruncateRate != "" {
+		cfg.chaosTruncateRate = chaosTruncateRate
+	}
+
+	logFormat := os.Getenv("LOG_FORMAT")
+	if logFormat != "" {
+		cfg.logFormat = logFormat
+	}
+
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel != "" {
+		cfg.logLevel = logLevel
+	}
+
+	compression := os.Getenv("COMPRESSION")
+	if compression != "" {
+		enabled, err := strconv.ParseBool(compression)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid COMPRESSION value", "value", compression)
+		} else {
+			cfg.compression = enabled
+		}
+	}
+
+	compressMinSize := os.Getenv("COMPRESS_MIN_SIZE")
+	if compressMinSize != "" {
+		size, err := strconv.Atoi(compressMinSize)
+		if err != nil {
+			cfg.log(slog.LevelWarn, "😟 Invalid COMPRESS_MIN_SIZE value", "value", compressMinSize)
+		} else {
+			cfg.compressMinSize = size
+		}
+	}
+
+	compressTypes := os.Getenv("COMPRESS_TYPES")
+	if compressTypes != "" {
+		cfg.compressTypes = compressTypes
+	}
+
+	tracing := strings.ToLower(os.Getenv("TRACING"))
+	if tracing != "" {
+		cfg.tracing = tracing
+	}
+
+	redact := strings.ToLower(os.Getenv("REDACT"))
+	if redact == "true" || redact == "1" {
+		cfg.redact = true
+	}
+
+	redactHeaders := os.Getenv("REDACT_HEADERS")
+	if redactHeaders != "" {
+		cfg.redactHeaders = redactHeaders
+	}
+
+	redactJSONPaths := os.Getenv("REDACT_JSON_PATHS")
+	if redactJSONPaths != "" {
+		cfg.redactJSONPaths = redactJSONPaths
+	}
+
+	redactPatterns := os.Getenv("REDACT_PATTERNS")
+	if redactPatterns != "" {
+		cfg.redactPatterns = redactPatterns
+	}
+
 	cfg.useTLS = false
 
 	// Check for TLS cert & key files if certPath is set
 	if cfg.certPath != "" {
-		log.Printf("🧬 Enabling TLS, checking cert & key files in: %s", cfg.certPath)
+		cfg.log(slog.LevelInfo, "🧬 Enabling TLS, checking cert & key files", "path", cfg.certPath)
 		cfg.useTLS = true
 
 		// Check cert & key files exist
 		if _, err := os.Stat(cfg.certPath + "/cert.pem"); os.IsNotExist(err) {
-			log.Printf("😟 cert.pem not found in cert path, TLS will be disabled")
+			cfg.log(slog.LevelWarn, "😟 cert.pem not found in cert path, TLS will be disabled")
 
 			cfg.useTLS = false
 		}
 
 		if _, err := os.Stat(cfg.certPath + "/key.pem"); os.IsNotExist(err) {
-			log.Printf("😟 key.pem not found in cert path, TLS will be disabled")
+			cfg.log(slog.LevelWarn, "😟 key.pem not found in cert path, TLS will be disabled")
 
 			cfg.useTLS = false
 		}
 	}
 }
+
+// Hold a log message until logging is set up, as the log format & level are loaded along with everything else
+func (cfg *Config) log(level slog.Level, msg string, args ...any) {
+	cfg.logs = append(cfg.logs, configLog{level: level, msg: msg, args: args})
+}
+
+// Log any messages held while loading the config, call this once logging has been set up
+func (cfg *Config) flushLogs() {
+	for _, l := range cfg.logs {
+		slog.Log(context.Background(), l.level, l.msg, l.args...)
+	}
+
+	cfg.logs = nil
+}
+
+// Options used when inspecting requests, built from the config
+func (cfg *Config) inspectOptions() httputil.Options {
+	return httputil.Options{
+		ReadBody:        cfg.bodyDebug,
+		FilePreviewSize: cfg.filePreviewSize,
+		MaxBodySize:     int64(cfg.maxBodySize) * 1024,
+	}
+}
+
+// Create the redactor for inspected requests, returns nil when redaction isn't enabled
+// Configuring any extra rules turns redaction on, so secrets can't leak because -redact was forgotten
+//
+//nolint:nilnil
+func (cfg *Config) newRedactor() (*redact.Redactor, error) {
+	if !cfg.redact && cfg.redactHeaders == "" && cfg.redactJSONPaths == "" && cfg.redactPatterns == "" {
+		return nil, nil
+	}
+
+	return redact.New(splitList(cfg.redactHeaders), splitList(cfg.redactJSONPaths), strings.Fields(cfg.redactPatterns))
+}
+
+// Chaos settings from the config, only including the ones which have been set
+func (cfg *Config) chaosSettings() map[string]string {
+	settings := map[string]string{}
+
+	for name, value := range map[string]string{
+		chaos.ErrorRate:    cfg.chaosErrorRate,
+		chaos.ErrorCodes:   cfg.chaosErrorCodes,
+		chaos.LatencySpec:  cfg.chaosLatency,
+		chaos.ResetRate:    cfg.chaosResetRate,
+		chaos.TruncateRate: cfg.chaosTruncateRate,
+	} {
+		if value != "" {
+			settings[name] = value
+		}
+	}
+
+	return settings
+}
+
+// Split a comma separated list, dropping any empty items
+func splitList(list string) []string {
+	items := []string{}
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Map of client auth modes we accept in config, to the tls package equivalent
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"none":            tls.NoClientCert,
+	"request":         tls.RequestClientCert,
+	"require":         tls.RequireAnyClientCert,
+	"verify-if-given": tls.VerifyClientCertIfGiven,
+	"verify":          tls.RequireAndVerifyClientCert,
+}
+
+// Build the TLS config for the server, including client certificate (mTLS) settings
+func (cfg *Config) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	// Normalised here, so the flag & env var are treated the same
+	clientAuth, ok := clientAuthModes[strings.ToLower(strings.TrimSpace(cfg.clientAuth))]
+	if !ok {
+		return nil, fmt.Errorf("invalid client auth mode: %s", cfg.clientAuth)
+	}
+
+	tlsConfig.ClientAuth = clientAuth
+
+	if cfg.clientCAPath != "" {
+		caPEM, err := os.ReadFile(cfg.clientCAPath)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no valid certificates found in %s", cfg.clientCAPath)
+		}
+	}
+
+	// Verifying modes are useless without a CA to verify against
+	if clientAuth >= tls.VerifyClientCertIfGiven && tlsConfig.ClientCAs == nil {
+		return nil, fmt.Errorf("client auth mode %s requires a client CA to be set", cfg.clientAuth)
+	}
+
+	return tlsConfig, nil
+}
+
+// Options for the compression middleware, built from the config
+func (cfg *Config) compressOptions() compress.Options {
+	return compress.Options{
+		MinSize: cfg.compressMinSize,
+		Types:   splitList(cfg.compressTypes),
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+// ==== http-toolkit: cookies.go ======================================================================================
+// Handlers to show, set & delete cookies, for debugging sessions & cookie rewriting by proxies
+// ====================================================================================================================
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Query parameters which control the attributes of cookies, these can't be used as cookie names
+const (
+	cookieSecure      = "secure"
+	cookieHTTPOnly    = "httpOnly"
+	cookieSameSite    = "sameSite"
+	cookieDomain      = "domain"
+	cookiePath        = "path"
+	cookieMaxAge      = "maxAge"
+	cookiePartitioned = "partitioned"
+)
+
+var cookieControls = []string{
+	cookieSecure, cookieHTTPOnly, cookieSameSite, cookieDomain, cookiePath, cookieMaxAge, cookiePartitioned,
+}
+
+var sameSiteModes = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// CookieList is returned by /cookies, with the cookies sent in the request
+type CookieList struct {
+	Cookies map[string]string `json:"cookies"`
+}
+
+// cookies returns the cookies sent with the request as JSON
+func cookies(w http.ResponseWriter, r *http.Request) {
+	list := CookieList{Cookies: map[string]string{}}
+
+	for _, cookie := range r.Cookies() {
+		list.Cookies[cookie.Name] = cookie.Value
+
+		if redactor != nil {
+			list.Cookies[cookie.Name] = redactor.Cookie(cookie.Name, cookie.Value)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// setCookies sets a cookie for each query parameter, apart from the ones controlling the cookie attributes,
+// then redirects to /cookies so they can be seen
+func setCookies(w http.ResponseWriter, r *http.Request) {
+	template, err := cookieTemplate(r)
+	if err != nil {
+		badRequest(w, "Invalid cookie options: "+err.Error())
+		return
+	}
+
+	values := map[string]string{}
+	for name := range r.URL.Query() {
+		values[name] = r.URL.Query().Get(name)
+	}
+
+	sendCookies(w, r, template, values)
+}
+
+// deleteCookies expires a cookie for each query parameter name, then redirects to /cookies
+// The domain & path have to match the ones the cookie was set with, or the browser will keep it
+func deleteCookies(w http.ResponseWriter, r *http.Request) {
+	template, err := cookieTemplate(r)
+	if err != nil {
+		badRequest(w, "Invalid cookie options: "+err.Error())
+		return
+	}
+
+	// A negative MaxAge is sent as Max-Age=0, which tells the browser to remove the cookie now
+	template.MaxAge = -1
+
+	values := map[string]string{}
+	for name := range r.URL.Query() {
+		values[name] = ""
+	}
+
+	sendCookies(w, r, template, values)
+}
+
+// Set a cookie for each name & value, apart from the control parameters, and redirect to /cookies
+// They are all checked first, so nothing is set if any are invalid
+func sendCookies(w http.ResponseWriter, r *http.Request, template http.Cookie, values map[string]string) {
+	toSet := []http.Cookie{}
+
+	for name, value := range values {
+		if isCookieControl(name) {
+			continue
+		}
+
+		cookie := template
+		cookie.Name = name
+		cookie.Value = value
+
+		if err := cookie.Valid(); err != nil {
+			badRequest(w, "Invalid cookie: "+err.Error())
+			return
+		}
+
+		toSet = append(toSet, cookie)
+	}
+
+	for i := range toSet {
+		http.SetCookie(w, &toSet[i])
+	}
+
+	http.Redirect(w, r, cfg.routePrefix+"cookies", http.StatusFound)
+}
+
+// Build a cookie with the attributes from the query parameters, the name & value are filled in later
+//
+//nolint:cyclop
+func cookieTemplate(r *http.Request) (http.Cookie, error) {
+	query := r.URL.Query()
+	cookie := http.Cookie{Path: "/", Domain: query.Get(cookieDomain)}
+
+	if query.Has(cookiePath) {
+		cookie.Path = query.Get(cookiePath)
+	}
+
+	var err error
+
+	if cookie.Secure, err = queryBool(query.Get(cookieSecure), query.Has(cookieSecure)); err != nil {
+		return cookie, fmt.Errorf("%s must be true or false", cookieSecure)
+	}
+
+	if cookie.HttpOnly, err = queryBool(query.Get(cookieHTTPOnly), query.Has(cookieHTTPOnly)); err != nil {
+		return cookie, fmt.Errorf("%s must be true or false", cookieHTTPOnly)
+	}
+
+	if cookie.Partitioned, err = queryBool(query.Get(cookiePartitioned), query.Has(cookiePartitioned)); err != nil {
+		return cookie, fmt.Errorf("%s must be true or false", cookiePartitioned)
+	}
+
+	if query.Has(cookieSameSite) {
+		mode, ok := sameSiteModes[strings.ToLower(query.Get(cookieSameSite))]
+		if !ok {
+			return cookie, fmt.Errorf("%s must be lax, strict or none", cookieSameSite)
+		}
+
+		cookie.SameSite = mode
+	}
+
+	if query.Has(cookieMaxAge) {
+		maxAge, err := strconv.Atoi(query.Get(cookieMaxAge))
+		if err != nil || maxAge < 0 {
+			return cookie, fmt.Errorf("%s must be a number of seconds", cookieMaxAge)
+		}
+
+		// Zero means the cookie expires straight away, which http.Cookie spells as a negative MaxAge
+		cookie.MaxAge = maxAge
+		if maxAge == 0 {
+			cookie.MaxAge = -1
+		}
+	}
+
+	return cookie, nil
+}
+
+// A flag in the query is true if it's given with no value e.g. ?secure, otherwise the value is parsed
+func queryBool(value string, present bool) (bool, error) {
+	if !present {
+		return false, nil
+	}
+
+	if value == "" {
+		return true, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
+func isCookieControl(name string) bool {
+	for _, control := range cookieControls {
+		if name == control {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+// ==== http-toolkit: formats.go ======================================================================================
+// Handlers returning sample responses in various formats & encodings, for testing content handling in clients
+// ====================================================================================================================
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"embed"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-chi/chi/v5"
+)
+
+// Sample files served by the format routes, embedded in the binary like the Swagger UI
+//
+//go:embed samples/*
+var samplesFS embed.FS
+
+// Content types of the sample images, keyed by the format in the /image route
+var imageTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"svg":  "image/svg+xml",
+	"webp": "image/webp",
+}
+
+// sample returns a handler which serves one of the embedded sample files with the given content type
+func sample(file string, contentType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := samplesFS.ReadFile("samples/" + file)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("Sample file not found"))
+
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+	}
+}
+
+// sampleImage serves the sample image in the requested format
+func sampleImage(w http.ResponseWriter, r *http.Request) {
+	format := chi.URLParam(r, "format")
+
+	contentType, ok := imageTypes[format]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Unsupported image format, must be png, jpeg, svg or webp"))
+
+		return
+	}
+
+	sample("sample."+format, contentType)(w, r)
+}
+
+// compressed returns a handler which responds with the inspected request, compressed with the given encoding
+// The response is always compressed, whatever the Accept-Encoding header of the request says
+func compressed(encoding string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var cw io.WriteCloser
+
+		switch encoding {
+		case "gzip":
+			cw = gzip.NewWriter(w)
+		case "deflate":
+			// Deflate in HTTP means zlib wrapped, not raw deflate
+			cw = zlib.NewWriter(w)
+		case "br":
+			cw = brotli.NewWriter(w)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", encoding)
+		w.WriteHeader(http.StatusOK)
+
+		enc := json.NewEncoder(cw)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(inspectRequest(r).Format(outputVersion(r)))
+
+		_ = cw.Close()
+	}
+}
@@ -5,8 +5,11 @@ package main
 // ====================================================================================================================
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"runtime"
 	"strconv"
@@ -22,6 +25,12 @@ import (
 	"github.com/google/uuid"
 )
 
+// Non standard status used by nginx, for requests where the client closed the connection before a response
+const statusClientClosed = 499
+
+// Context key for request details, so a request inspected by the debug middleware isn't inspected again
+type requestDetailsKey struct{}
+
 // Used by the info handler to generate some useful system information
 type SystemInfo struct {
 	Hostname     string `json:"hostname"`
@@ -39,9 +48,54 @@ func inspect(w http.ResponseWriter, r *http.Request) {
 	// Return a JSON response with the request details
 	w.Header().Set("Content-Type", "application/json")
 
+	reqDetails := inspectRequest(r)
+	if requestHistory != nil {
+		requestHistory.Add(reqDetails)
+	}
+
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
-	_ = enc.Encode(httputil.NewRequestDetails(r, cfg.bodyDebug))
+	_ = enc.Encode(reqDetails.Format(outputVersion(r)))
+}
+
+// inspectRequest gets the details of a request, with any sensitive values redacted if enabled
+// If the request has already been inspected, the details stored in the context are returned
+func inspectRequest(r *http.Request) httputil.RequestDetails {
+	if reqDetails, ok := r.Context().Value(requestDetailsKey{}).(httputil.RequestDetails); ok {
+		return reqDetails
+	}
+
+	reqDetails := httputil.NewRequestDetailsWithOptions(r, cfg.inspectOptions())
+	if redactor != nil {
+		redactor.Apply(&reqDetails)
+	}
+
+	return reqDetails
+}
+
+// withRequestDetails returns a copy of the request with its details stored in the context, see inspectRequest
+func withRequestDetails(r *http.Request, reqDetails httputil.RequestDetails) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestDetailsKey{}, reqDetails))
+}
+
+// outputVersion is the format version to use when returning request details
+// Clients can pick a version with the X-Inspect-Version header, otherwise the configured default is used
+func outputVersion(r *http.Request) int {
+	if ver, err := strconv.Atoi(r.Header.Get("X-Inspect-Version")); err == nil {
+		return ver
+	}
+
+	return cfg.inspectVersion
+}
+
+// Format a list of request details for output, see outputVersion
+func formatAll(r *http.Request, requests []httputil.RequestDetails) []httputil.RequestDetails {
+	formatted := make([]httputil.RequestDetails, len(requests))
+	for i, rd := range requests {
+		formatted[i] = rd.Format(outputVersion(r))
+	}
+
+	return formatted
 }
 
 func ok(w http.ResponseWriter, r *http.Request) {
@@ -83,11 +137,21 @@ func statusCode(w http.ResponseWriter, r *http.Request) {
 		code = "200"
 	}
 
+	// WriteHeader panics for anything that isn't three digits
 	status, err := strconv.Atoi(code)
-	if err != nil {
-		status = http.StatusBadRequest
+	if err != nil || status < 100 || status > 999 {
+		badRequest(w, "Invalid code value, must be 100-999")
+		return
+	}
+
+	// Keep the label to the standard range, so odd codes can't create an unlimited number of series
+	label := "invalid"
+	if status <= 599 {
+		label = strconv.Itoa(status)
 	}
 
+	forcedStatus.WithLabelValues(label).Inc()
+
 	w.WriteHeader(status)
 	_, _ = w.Write([]byte(http.StatusText(status)))
 }
@@ -166,23 +230,125 @@ func randomUUID(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte(u.String()))
 }
 
+// delay waits before responding, the delay can be given in the path as seconds or a duration e.g. 250ms or 1.5s
+// or picked at random between the min & max query parameters, jitter adds up to +/- that amount at random
+// With no options the delay is a random whole number of seconds between 1 and 10
 func delay(w http.ResponseWriter, r *http.Request) {
-	delay := chi.URLParam(r, "seconds")
-	if delay == "" {
-		// Random delay between 1 and 10 seconds
-		delay = strconv.Itoa(rand.Intn(10) + 1)
-	}
-
-	delayInt, err := strconv.Atoi(delay)
+	requested, err := requestedDelay(r)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("Invalid delay value"))
+		_, _ = w.Write([]byte("Invalid delay value: " + err.Error()))
+
+		return
+	}
+
+	applied := requested
+	if maxDelay := time.Duration(cfg.maxDelay) * time.Second; maxDelay > 0 && requested > maxDelay {
+		applied = maxDelay
+		w.Header().Set("X-Delay-Capped", "true")
+	}
+
+	httputil.ExtendWriteDeadline(w, r, applied)
+
+	delaysServed.Inc()
+
+	start := time.Now()
+	timer := time.NewTimer(applied)
+
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+		// The client has gone away, so nothing will be sent, but the status shows up in logs & metrics
+		delaySeconds.Add(time.Since(start).Seconds())
+		w.WriteHeader(statusClientClosed)
 
 		return
 	}
 
-	time.Sleep(time.Duration(delayInt) * time.Second)
+	delaySeconds.Add(applied.Seconds())
 
+	w.Header().Set("X-Delay-Applied", applied.String())
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("OK"))
 }
+
+// Work out the delay asked for from the path & query parameters, before it is capped
+//
+//nolint:cyclop
+func requestedDelay(r *http.Request) (time.Duration, error) {
+	var requested time.Duration
+
+	query := r.URL.Query()
+	param := chi.URLParam(r, "seconds")
+
+	switch {
+	case param != "":
+		d, err := parseDuration(param)
+		if err != nil {
+			return 0, err
+		}
+
+		requested = d
+
+	case query.Has("min") || query.Has("max"):
+		// Without a max the range goes up to the cap, or 10 seconds if there isn't one
+		minDelay, maxDelay := time.Duration(0), 10*time.Second
+		if cfg.maxDelay > 0 {
+			maxDelay = time.Duration(cfg.maxDelay) * time.Second
+		}
+
+		for name, target := range map[string]*time.Duration{"min": &minDelay, "max": &maxDelay} {
+			if value := query.Get(name); value != "" {
+				d, err := parseDuration(value)
+				if err != nil {
+					return 0, fmt.Errorf("%s: %w", name, err)
+				}
+
+				*target = d
+			}
+		}
+
+		// The default max is only a starting point, a longer min moves it along
+		if query.Get("max") == "" {
+			maxDelay = max(maxDelay, minDelay)
+		}
+
+		if maxDelay < minDelay {
+			return 0, errors.New("max must not be less than min")
+		}
+
+		requested = randomDuration(minDelay, maxDelay)
+
+	default:
+		//nolint:gosec
+		requested = time.Duration(rand.Intn(10)+1) * time.Second
+	}
+
+	if value := query.Get("jitter"); value != "" {
+		jitter, err := parseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("jitter: %w", err)
+		}
+
+		// Nothing is capped until later, so keep the jitter range & the sum within what a Duration can hold
+		jitter = min(jitter, math.MaxInt64/2)
+		requested = max(min(requested, math.MaxInt64-jitter)+randomDuration(-jitter, jitter), 0)
+	}
+
+	// Random delays are rounded, as nanosecond precision is just noise
+	return requested.Round(time.Millisecond), nil
+}
+
+// Random duration between min & max inclusive, the range must fit in a Duration
+func randomDuration(minDuration, maxDuration time.Duration) time.Duration {
+	// Including max would overflow for the widest possible range, so it's left out in that one case
+	span := int64(maxDuration - minDuration)
+	if span < math.MaxInt64 {
+		span++
+	}
+
+	//nolint:gosec
+	return minDuration + time.Duration(rand.Int63n(span))
+}
@@ -3,15 +3,38 @@
 package main
 
 import (
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/andybalholm/brotli"
+	"github.com/benc-uk/http-toolkit/pkg/chaos"
+	"github.com/benc-uk/http-toolkit/pkg/history"
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+	"github.com/benc-uk/http-toolkit/pkg/redact"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func TestOkHandler(t *testing.T) {
@@ -35,6 +58,30 @@ func TestOkHandler(t *testing.T) {
 	}
 }
 
+func TestStatusCodeHandler(t *testing.T) {
+	router := http.NewServeMux()
+	router.HandleFunc("/status/{code}", statusCode)
+
+	before := testutil.ToFloat64(forcedStatus.WithLabelValues("invalid"))
+
+	tests := map[string]int{"418": http.StatusTeapot, "799": 799, "1000": http.StatusBadRequest,
+		"42": http.StatusBadRequest, "-200": http.StatusBadRequest, "abc": http.StatusBadRequest}
+
+	for code, expected := range tests {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status/"+code, nil))
+
+		if rr.Code != expected {
+			t.Errorf("status %s returned %d, expected %d", code, rr.Code, expected)
+		}
+	}
+
+	// Only 799 is in range but non-standard
+	if got := testutil.ToFloat64(forcedStatus.WithLabelValues("invalid")) - before; got != 1 {
+		t.Errorf("expected 1 invalid forced status, got %v", got)
+	}
+}
+
 func TestSystemInfoHandler(t *testing.T) {
 	req, err := http.NewRequest(http.MethodGet, "/system-info", nil)
 	if err != nil {
@@ -148,3 +195,1084 @@ func TestRandomUUID(t *testing.T) {
 		t.Errorf("handler returned invalid UUID: %v", err)
 	}
 }
+
+func TestInspectAddsToHistory(t *testing.T) {
+	cfg = NewConfig()
+	requestHistory = history.NewStore(10)
+	defer func() { requestHistory = nil }()
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"hello":"world"}`))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(inspect).ServeHTTP(rr, req)
+
+	var inspected httputil.RequestDetails
+	if err := json.Unmarshal(rr.Body.Bytes(), &inspected); err != nil {
+		t.Fatalf("inspect returned invalid JSON: %v", err)
+	}
+
+	// Fetch the same request back from the history API
+	req = httptest.NewRequest(http.MethodGet, "/history/"+inspected.ID, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", inspected.ID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(historyGet).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("history returned wrong status code: got %v, want %v", status, http.StatusOK)
+	}
+
+	var stored httputil.RequestDetails
+	if err := json.Unmarshal(rr.Body.Bytes(), &stored); err != nil {
+		t.Fatalf("history returned invalid JSON: %v", err)
+	}
+
+	if stored.Path != "/webhook" || stored.Body != `{"hello":"world"}` {
+		t.Errorf("history returned unexpected request: %+v", stored)
+	}
+}
+
+func TestInspectOnceWithDebug(t *testing.T) {
+	cfg = NewConfig()
+	requestHistory = history.NewStore(10)
+	defer func() { requestHistory = nil }()
+
+	buf := &strings.Builder{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"hello":"world"}`))
+	rr := httptest.NewRecorder()
+	reqDebugMiddleware(http.HandlerFunc(inspect)).ServeHTTP(rr, req)
+
+	var record struct {
+		Request httputil.RequestDetails `json:"request"`
+	}
+	if err := json.Unmarshal([]byte(buf.String()), &record); err != nil {
+		t.Fatalf("expected a single JSON log record, got %s", buf.String())
+	}
+
+	var inspected httputil.RequestDetails
+	if err := json.Unmarshal(rr.Body.Bytes(), &inspected); err != nil {
+		t.Fatalf("inspect returned invalid JSON: %v", err)
+	}
+
+	// The same details should be logged, returned & stored, rather than the request being inspected twice
+	if inspected.ID == "" || inspected.ID != record.Request.ID || inspected.Body != `{"hello":"world"}` {
+		t.Errorf("expected logged & returned details to match, got %s and %s", record.Request.ID, inspected.ID)
+	}
+
+	if stored, found := requestHistory.Get(inspected.ID); !found || stored.ID != inspected.ID {
+		t.Errorf("expected inspected request in history, got %+v", stored)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := &strings.Builder{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+
+	handler := middleware.RequestID(accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("short and stout"))
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/teapot?q=1", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var record map[string]any
+	if err := json.Unmarshal([]byte(buf.String()), &record); err != nil {
+		t.Fatalf("expected a single JSON log record, got %s", buf.String())
+	}
+
+	if record["msg"] != "Request" || record["uri"] != "/teapot?q=1" || record["status"] != float64(http.StatusTeapot) {
+		t.Errorf("unexpected access log record: %v", record)
+	}
+
+	if record["requestId"] == "" || record["requestId"] != rr.Header().Get("X-Request-Id") {
+		t.Errorf("expected request ID in record & response header, got %v %s", record["requestId"], rr.Header().Get("X-Request-Id"))
+	}
+}
+
+func TestConfigLogsHeldUntilFlush(t *testing.T) {
+	buf := &strings.Builder{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+
+	t.Setenv("HISTORY_SIZE", "lots")
+
+	c := NewConfig()
+	c.loadEnv()
+
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing logged before logging is set up, got %s", buf.String())
+	}
+
+	c.flushLogs()
+
+	if !strings.Contains(buf.String(), "Invalid HISTORY_SIZE value") || !strings.Contains(buf.String(), `"value":"lots"`) {
+		t.Errorf("expected invalid value warning once flushed, got %s", buf.String())
+	}
+}
+
+func TestConfigCompressionEnv(t *testing.T) {
+	t.Setenv("COMPRESSION", "off")
+
+	c := NewConfig()
+	c.loadEnv()
+
+	if !c.compression || len(c.logs) != 1 || c.logs[0].msg != "😟 Invalid COMPRESSION value" {
+		t.Errorf("expected invalid value to be warned about & ignored, got %v", c.logs)
+	}
+
+	t.Setenv("COMPRESSION", "F")
+
+	c = NewConfig()
+	c.loadEnv()
+
+	if c.compression || len(c.logs) != 0 {
+		t.Errorf("expected compression to be disabled, got %v", c.logs)
+	}
+}
+
+func TestTLSConfigClientAuth(t *testing.T) {
+	c := NewConfig()
+	c.clientAuth = "Require"
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil || tlsConfig.ClientAuth != tls.RequireAnyClientCert {
+		t.Errorf("expected mixed case client auth mode to be accepted, got %v", err)
+	}
+
+	c.clientAuth = "sometimes"
+	if _, err := c.tlsConfig(); err == nil {
+		t.Errorf("expected error for invalid client auth mode")
+	}
+}
+
+func TestSetupLoggingInvalid(t *testing.T) {
+	if err := setupLogging("xml", "info"); err == nil {
+		t.Errorf("expected error for invalid log format")
+	}
+
+	if err := setupLogging("json", "loud"); err == nil {
+		t.Errorf("expected error for invalid log level")
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(metricsMiddleware(router))
+	router.HandleFunc("/status/{code}", statusCode)
+
+	before := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/status/{code}", "503"))
+	beforeForced := testutil.ToFloat64(forcedStatus.WithLabelValues("503"))
+
+	for _, path := range []string{"/status/503", "/status/503", "/nothing/here"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/status/{code}", "503")) - before; got != 2 {
+		t.Errorf("expected 2 requests counted against the route pattern, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(forcedStatus.WithLabelValues("503")) - beforeForced; got != 2 {
+		t.Errorf("expected 2 forced status codes, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, unmatchedRoute, "404")); got < 1 {
+		t.Errorf("expected unmatched request to be counted, got %v", got)
+	}
+
+	// Made up methods all share one label, rather than each creating new series
+	beforeOther := testutil.ToFloat64(httpRequests.WithLabelValues("other", unmatchedRoute, "405"))
+
+	for _, method := range []string{"BREW", "WHEN"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/status/200", nil))
+	}
+
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("other", unmatchedRoute, "405")) - beforeOther; got != 2 {
+		t.Errorf("expected 2 requests with other methods, got %v", got)
+	}
+}
+
+func TestMetricsChaos(t *testing.T) {
+	engine := chaos.New()
+
+	router := chi.NewRouter()
+	router.Use(metricsMiddleware(router))
+	router.Use(engine.Middleware)
+	router.Get("/ok", ok)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	before := testutil.ToFloat64(chaosInjected.WithLabelValues("reset"))
+	beforeStatus := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/ok", "reset"))
+	beforeError := testutil.ToFloat64(chaosInjected.WithLabelValues("error"))
+	beforeLatency := testutil.ToFloat64(chaosInjected.WithLabelValues("latency"))
+
+	_ = engine.Update(map[string]string{chaos.ResetRate: "100", chaos.LatencySpec: "fixed:1ms"})
+
+	if resp, err := http.Get(server.URL + "/ok"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected connection to be reset, got %d", resp.StatusCode)
+	}
+
+	_ = engine.Update(map[string]string{chaos.ResetRate: "0", chaos.ErrorRate: "100", chaos.LatencySpec: "none"})
+
+	resp, err := http.Get(server.URL + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Resets send no response, so shouldn't be counted as a 200
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/ok", "reset")) - beforeStatus; got != 1 {
+		t.Errorf("expected reset to be counted with a reset status, got %v", got)
+	}
+
+	if testutil.ToFloat64(chaosInjected.WithLabelValues("reset"))-before != 1 ||
+		testutil.ToFloat64(chaosInjected.WithLabelValues("error"))-beforeError != 1 ||
+		testutil.ToFloat64(chaosInjected.WithLabelValues("latency"))-beforeLatency != 1 {
+		t.Errorf("expected one each of reset, error & latency chaos to be counted")
+	}
+}
+
+func TestTracingRouteMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	router := chi.NewRouter()
+	router.Use(tracingRouteMiddleware)
+	router.Get("/status/{code}", statusCode)
+
+	req := httptest.NewRequest(http.MethodGet, "/status/418", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	tracingHandler(router).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+
+	if spans[0].Name() != "GET /status/{code}" {
+		t.Errorf("expected span to be named after the route, got %s", spans[0].Name())
+	}
+
+	if spans[0].SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected span to continue the incoming trace, got %s", spans[0].SpanContext().TraceID())
+	}
+}
+
+func TestSetupTracingInvalid(t *testing.T) {
+	if _, err := setupTracing("jaeger"); err == nil {
+		t.Errorf("expected error for invalid tracing exporter")
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	defer resetShutdown()
+
+	rr := httptest.NewRecorder()
+	ready(rr, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected ready to return 200 before shutdown, got %d", rr.Code)
+	}
+
+	beginShutdown()
+
+	rr = httptest.NewRecorder()
+	ready(rr, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected ready to return 503 during shutdown, got %d", rr.Code)
+	}
+}
+
+func TestGracefulShutdownDrains(t *testing.T) {
+	defer resetShutdown()
+
+	cfg.drainTimeout = 5
+	cfg.preStopDelay = 0
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	})
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: time.Second}
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- runServer(server, func() error { return server.Serve(listener) })
+	}()
+
+	body := make(chan string, 1)
+
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+
+		data, _ := io.ReadAll(resp.Body)
+		body <- string(data)
+	}()
+
+	<-started
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-body; got != "done" {
+		t.Errorf("expected in-flight request to complete, got %s", got)
+	}
+
+	if err := <-result; err != nil {
+		t.Errorf("expected clean shutdown, got %v", err)
+	}
+
+	if !shuttingDown() {
+		t.Errorf("expected shutdown to have begun")
+	}
+}
+
+func resetShutdown() {
+	shutdownCh = make(chan struct{})
+	shutdownOnce = sync.Once{}
+}
+
+func TestHealthAdmin(t *testing.T) {
+	defer probes["ready"].Reset()
+
+	router := chi.NewRouter()
+	router.Get("/health/ready", ready)
+	router.Post("/admin/health/{probe}", healthFail)
+	router.Delete("/admin/health/{probe}", healthReset)
+
+	check := func() int {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+
+		return rr.Code
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/health/ready?every=2&status=500", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 setting failure, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if first, second := check(), check(); first != http.StatusOK || second != http.StatusInternalServerError {
+		t.Errorf("expected every 2nd check to fail with 500, got %d then %d", first, second)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/admin/health/ready", nil))
+
+	if code := check(); code != http.StatusOK {
+		t.Errorf("expected readiness to pass after reset, got %d", code)
+	}
+
+	for _, url := range []string{"/admin/health/nope", "/admin/health/ready?duration=soon", "/admin/health/ready?status=200"} {
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, url, nil))
+
+		if rr.Code != http.StatusNotFound && rr.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %d", url, rr.Code)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"30":    30 * time.Second,
+		"1.5":   1500 * time.Millisecond,
+		"250ms": 250 * time.Millisecond,
+		"2m":    2 * time.Minute,
+	}
+
+	for value, expected := range tests {
+		if d, err := parseDuration(value); err != nil || d != expected {
+			t.Errorf("expected %s to parse as %v, got %v %v", value, expected, d, err)
+		}
+	}
+
+	for _, value := range []string{"", "soon", "-5s"} {
+		if _, err := parseDuration(value); err == nil {
+			t.Errorf("expected %q to be invalid", value)
+		}
+	}
+}
+
+func TestChaosAdmin(t *testing.T) {
+	chaosEngine = chaos.New("/admin")
+
+	router := chi.NewRouter()
+	router.Use(chaosEngine.Middleware)
+	router.Get("/uuid", randomUUID)
+	router.Post("/admin/chaos", chaosUpdate)
+	router.Delete("/admin/chaos", chaosReset)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/chaos?errorRate=100&errorCodes=503", nil))
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"errorRate": 100`) {
+		t.Fatalf("expected chaos to be updated, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/uuid", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected chaos error 503, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/chaos?latency=gamma:1s", nil))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid latency to be rejected, got %d", rr.Code)
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/admin/chaos", nil))
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/uuid", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected no chaos after reset, got %d", rr.Code)
+	}
+}
+
+func TestDelayOptions(t *testing.T) {
+	cfg = NewConfig()
+	cfg.maxDelay = 1
+
+	router := chi.NewRouter()
+	router.HandleFunc("/delay", delay)
+	router.HandleFunc("/delay/{seconds}", delay)
+
+	tests := []struct {
+		url     string
+		status  int
+		applied string
+		capped  bool
+	}{
+		{"/delay/250ms", http.StatusOK, "250ms", false},
+		{"/delay/0.1", http.StatusOK, "100ms", false},
+		{"/delay/5s", http.StatusOK, "1s", true},
+		{"/delay?min=50ms&max=50ms", http.StatusOK, "50ms", false},
+		{"/delay/100ms?jitter=0s", http.StatusOK, "100ms", false},
+		{"/delay/soon", http.StatusBadRequest, "", false},
+		{"/delay?min=2s&max=1s", http.StatusBadRequest, "", false},
+		{"/delay/1s?jitter=lots", http.StatusBadRequest, "", false},
+		{"/delay?min=2s&max=3s", http.StatusOK, "1s", true},
+	}
+
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, test.url, nil))
+
+		if rr.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.url, test.status, rr.Code)
+		}
+
+		if rr.Header().Get("X-Delay-Applied") != test.applied {
+			t.Errorf("%s: expected delay applied %q, got %q", test.url, test.applied, rr.Header().Get("X-Delay-Applied"))
+		}
+
+		if (rr.Header().Get("X-Delay-Capped") == "true") != test.capped {
+			t.Errorf("%s: expected capped to be %v", test.url, test.capped)
+		}
+	}
+}
+
+func TestDelayOversized(t *testing.T) {
+	cfg = NewConfig()
+	cfg.maxDelay = 1
+
+	router := chi.NewRouter()
+	router.HandleFunc("/delay", delay)
+	router.HandleFunc("/delay/{seconds}", delay)
+
+	// Jitter & ranges this big would overflow when sampled, if they weren't limited first
+	urls := []string{"/delay/100ms?jitter=2562047h", "/delay/2562047h?jitter=2562047h", "/delay?min=2000000h&max=2562047h"}
+
+	for _, url := range urls {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+
+		applied, err := time.ParseDuration(rr.Header().Get("X-Delay-Applied"))
+		if rr.Code != http.StatusOK || err != nil || applied > time.Second {
+			t.Errorf("%s: expected delay within the cap, got %d %q", url, rr.Code, rr.Header().Get("X-Delay-Applied"))
+		}
+	}
+}
+
+func TestRequestedDelayNoCap(t *testing.T) {
+	cfg = NewConfig()
+	cfg.maxDelay = 0
+
+	requested := func(url string, seconds string) time.Duration {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("seconds", seconds)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		d, err := requestedDelay(req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", url, err)
+		}
+
+		return d
+	}
+
+	// With no cap, ranges aren't cut down to the default 10 seconds
+	if d := requested("/delay?min=20s&max=30s", ""); d < 20*time.Second || d > 30*time.Second {
+		t.Errorf("expected delay between 20s & 30s, got %v", d)
+	}
+
+	if d := requested("/delay?min=1h", ""); d < time.Hour {
+		t.Errorf("expected delay of at least the min, got %v", d)
+	}
+
+	// Without a max the default range is still used
+	if d := requested("/delay?min=0s", ""); d > 10*time.Second {
+		t.Errorf("expected delay of at most 10s without a max, got %v", d)
+	}
+
+	if d := requested("/delay/30s?jitter=20s", "30s"); d < 10*time.Second || d > 50*time.Second {
+		t.Errorf("expected jitter of up to 20s, got %v", d)
+	}
+
+	// These would overflow if they weren't kept in range
+	oversized := map[string]string{
+		"/delay?min=0s&max=9223372036854775807ns": "",
+		"/delay/1s?jitter=2562047h":               "1s",
+		"/delay/2562047h?jitter=2562047h":         "2562047h",
+	}
+
+	for url, seconds := range oversized {
+		if d := requested(url, seconds); d < 0 {
+			t.Errorf("%s: expected delay not to be negative, got %v", url, d)
+		}
+	}
+}
+
+func TestDelayCancelled(t *testing.T) {
+	cfg = NewConfig()
+
+	router := chi.NewRouter()
+	router.HandleFunc("/delay/{seconds}", delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/delay/10", nil).WithContext(ctx))
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected delay to stop when the request was cancelled, took %v", elapsed)
+	}
+
+	if rr.Code != statusClientClosed || rr.Body.Len() != 0 {
+		t.Errorf("expected no response & a 499 status for a cancelled request, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestStreamHandlers(t *testing.T) {
+	cfg = NewConfig()
+
+	router := chi.NewRouter()
+	router.Get("/stream/{n}", streamLines)
+	router.Get("/drip", drip)
+	router.Get("/chunked", chunked)
+	router.Get("/sse/{n}", serverSentEvents)
+
+	get := func(url string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if header != nil {
+			req.Header = header
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	rr := get("/stream/3", nil)
+	if lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n"); len(lines) != 3 || !rr.Flushed {
+		t.Errorf("expected 3 flushed lines, got %d", len(lines))
+	}
+
+	rr = get("/drip?numbytes=4&duration=40ms&code=202", nil)
+	if rr.Code != http.StatusAccepted || rr.Body.String() != "****" || rr.Header().Get("Content-Length") != "4" {
+		t.Errorf("unexpected drip response: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = get("/chunked?chunks=3&size=1,2&interval=0", nil)
+	if rr.Body.String() != "abbc" {
+		t.Errorf("unexpected chunked body: %s", rr.Body.String())
+	}
+
+	rr = get("/sse/3?interval=0&event=tick", http.Header{"Last-Event-Id": {"0"}})
+	if rr.Header().Get("Content-Type") != "text/event-stream" || strings.Count(rr.Body.String(), "event: tick") != 2 ||
+		!strings.HasPrefix(rr.Body.String(), "id: 1\n") {
+		t.Errorf("unexpected sse response: %s", rr.Body.String())
+	}
+
+	// Out of range IDs start from the beginning, or send nothing, rather than panicking
+	rr = get("/sse/3?interval=0", http.Header{"Last-Event-Id": {"-5"}})
+	if strings.Count(rr.Body.String(), "event: message") != 3 || !strings.HasPrefix(rr.Body.String(), "id: 0\n") {
+		t.Errorf("unexpected sse response for negative ID: %s", rr.Body.String())
+	}
+
+	rr = get("/sse/3?interval=0", http.Header{"Last-Event-Id": {"9223372036854775807"}})
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "event:") {
+		t.Errorf("unexpected sse response for huge ID: %s", rr.Body.String())
+	}
+
+	for _, url := range []string{"/stream/5000", "/drip?code=100", "/chunked?size=0", "/sse/100?interval=1h"} {
+		if rr := get(url, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %d", url, rr.Code)
+		}
+	}
+
+	// Totals which overflow would wrap round to something tiny & slip under the max delay
+	for _, url := range []string{
+		"/stream/3?interval=6148914691236517206ns",
+		"/drip?delay=9223372036854775807ns&duration=1s",
+		"/chunked?chunks=4&interval=4611686018427387904ns",
+		"/sse/3?interval=6148914691236517206ns",
+	} {
+		if rr := get(url, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %d", url, rr.Code)
+		}
+	}
+}
+
+func TestStreamTime(t *testing.T) {
+	if got := streamTime(3, time.Second); got != 3*time.Second {
+		t.Errorf("expected 3s, got %s", got)
+	}
+
+	if got := streamTime(3, 6148914691236517206); got != math.MaxInt64 {
+		t.Errorf("expected overflowing total to stop at the longest duration, got %s", got)
+	}
+
+	if got := addDurations(math.MaxInt64, time.Second); got != math.MaxInt64 {
+		t.Errorf("expected overflowing sum to stop at the longest duration, got %s", got)
+	}
+
+	if got := addDurations(time.Second, 2*time.Second); got != 3*time.Second {
+		t.Errorf("expected 3s, got %s", got)
+	}
+}
+
+func TestByteHandlers(t *testing.T) {
+	router := chi.NewRouter()
+	router.Get("/bytes/{n}", randomBytes)
+	router.Get("/range/{n}", rangeBytes)
+	router.Get("/stream-bytes/{n}", streamBytes)
+
+	get := func(url string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if header != nil {
+			req.Header = header
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	first := get("/bytes/64?seed=42", nil)
+	second := get("/bytes/64?seed=42", nil)
+
+	if first.Body.Len() != 64 || first.Body.String() != second.Body.String() || first.Header().Get("X-Seed") != "42" {
+		t.Errorf("expected the same 64 bytes for the same seed")
+	}
+
+	rr := get("/stream-bytes/25?chunk_size=10&seed=42", nil)
+	if rr.Body.Len() != 25 || !rr.Flushed || rr.Body.String()[:10] != first.Body.String()[:10] {
+		t.Errorf("unexpected stream-bytes response: %d bytes", rr.Body.Len())
+	}
+
+	rr = get("/range/100", http.Header{"Range": {"bytes=26-28"}})
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != "abc" ||
+		rr.Header().Get("Content-Range") != "bytes 26-28/100" {
+		t.Errorf("unexpected range response: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = get("/range/100", http.Header{"Range": {"bytes=0-1,50-51"}})
+	if rr.Code != http.StatusPartialContent ||
+		!strings.HasPrefix(rr.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Errorf("expected a multipart response, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	// A stale If-Range means the whole content is sent
+	rr = get("/range/100", http.Header{"Range": {"bytes=0-1"}, "If-Range": {`"range-99"`}})
+	if rr.Code != http.StatusOK || rr.Body.Len() != 100 {
+		t.Errorf("expected full content for stale If-Range, got %d", rr.Code)
+	}
+
+	for _, url := range []string{"/bytes/x", "/range/-1", "/stream-bytes/10?chunk_size=0", "/bytes/10?seed=x"} {
+		if rr := get(url, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %d", url, rr.Code)
+		}
+	}
+}
+
+func TestFormatHandlers(t *testing.T) {
+	cfg = NewConfig()
+
+	router := chi.NewRouter()
+	router.Get("/json", sample("sample.json", "application/json"))
+	router.Get("/xml", sample("sample.xml", "application/xml"))
+	router.Get("/image/{format}", sampleImage)
+	router.Get("/gzip", compressed("gzip"))
+	router.Get("/deflate", compressed("deflate"))
+	router.Get("/brotli", compressed("br"))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+
+		return rr
+	}
+
+	rr := get("/json")
+	if rr.Header().Get("Content-Type") != "application/json" || !json.Valid(rr.Body.Bytes()) {
+		t.Errorf("expected valid JSON, got %s", rr.Body.String())
+	}
+
+	if rr := get("/xml"); !strings.HasPrefix(rr.Body.String(), "<?xml") {
+		t.Errorf("expected XML, got %s", rr.Body.String())
+	}
+
+	// Check the magic bytes at the start of each image match the format
+	images := map[string]string{"png": "\x89PNG", "jpeg": "\xff\xd8\xff", "svg": "<svg", "webp": "RIFF"}
+	for format, magic := range images {
+		rr := get("/image/" + format)
+		if rr.Header().Get("Content-Type") != imageTypes[format] || !strings.HasPrefix(rr.Body.String(), magic) {
+			t.Errorf("unexpected %s image response: %s", format, rr.Header().Get("Content-Type"))
+		}
+	}
+
+	if rr := get("/image/gif"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unsupported image format, got %d", rr.Code)
+	}
+
+	readers := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+		"br":      func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+
+	for url, encoding := range map[string]string{"/gzip": "gzip", "/deflate": "deflate", "/brotli": "br"} {
+		rr := get(url)
+		if rr.Header().Get("Content-Encoding") != encoding {
+			t.Errorf("expected %s content encoding, got %s", encoding, rr.Header().Get("Content-Encoding"))
+			continue
+		}
+
+		reader, err := readers[encoding](rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		details := httputil.RequestDetails{}
+		if err := json.NewDecoder(reader).Decode(&details); err != nil || details.Path != url {
+			t.Errorf("expected %s response to decode to the request details, got %v %+v", url, err, details)
+		}
+	}
+}
+
+func TestStaticPrecompressed(t *testing.T) {
+	dir := t.TempDir()
+	cfg = NewConfig()
+	cfg.staticPath = dir
+
+	var compressed strings.Builder
+
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write([]byte("console.log('hello')"))
+	_ = gz.Close()
+
+	_ = os.WriteFile(dir+"/app.js", []byte("console.log('hello')"), 0o600)
+	_ = os.WriteFile(dir+"/app.js.gz", []byte(compressed.String()), 0o600)
+
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		rr := httptest.NewRecorder()
+		staticServe(rr, req)
+
+		return rr
+	}
+
+	rr := get("gzip, br")
+	if rr.Header().Get("Content-Encoding") != "gzip" || rr.Body.String() != compressed.String() ||
+		!strings.HasPrefix(rr.Header().Get("Content-Type"), "text/javascript") {
+		t.Errorf("expected precompressed file, got %v", rr.Header())
+	}
+
+	rr = get("br")
+	if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != "console.log('hello')" ||
+		rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("expected original file, got %v", rr.Header())
+	}
+}
+
+func TestStaticPrecompressedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	cfg = NewConfig()
+	cfg.staticPath = dir + "/site"
+	cfg.spaPath = dir + "/site"
+
+	// A sibling of the directory itself is outside what's being served, so must never be used
+	_ = os.Mkdir(dir+"/site", 0o700)
+	_ = os.Mkdir(dir+"/site/sub", 0o700)
+	_ = os.WriteFile(dir+"/site.gz", []byte("secret"), 0o600)
+	_ = os.WriteFile(dir+"/site/index.html", []byte("<h1>hello</h1>"), 0o600)
+	_ = os.WriteFile(dir+"/site/sub.gz", []byte("secret"), 0o600)
+
+	get := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		return rr
+	}
+
+	for _, handler := range []http.HandlerFunc{staticServe, spaServe} {
+		if rr := get(handler, "/"); rr.Body.String() != "<h1>hello</h1>" || rr.Header().Get("Content-Encoding") != "" {
+			t.Errorf("expected uncompressed index for root, got %s", rr.Body.String())
+		}
+
+		if rr := get(handler, "/sub/"); strings.Contains(rr.Body.String(), "secret") {
+			t.Errorf("expected sibling of directory not to be served, got %s", rr.Body.String())
+		}
+	}
+
+	_ = os.WriteFile(dir+"/site/index.html.gz", []byte("compressed"), 0o600)
+
+	for _, handler := range []http.HandlerFunc{staticServe, spaServe} {
+		rr := get(handler, "/")
+		if rr.Body.String() != "compressed" || rr.Header().Get("Content-Encoding") != "gzip" ||
+			!strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("expected precompressed index for root, got %v %s", rr.Header(), rr.Body.String())
+		}
+	}
+
+	// Directories without a trailing slash are left for the file server to redirect
+	if rr := get(staticServe, "/sub"); rr.Code != http.StatusMovedPermanently {
+		t.Errorf("expected redirect for directory without trailing slash, got %d", rr.Code)
+	}
+}
+
+func TestCookieHandlers(t *testing.T) {
+	cfg = NewConfig()
+
+	router := chi.NewRouter()
+	router.Get("/cookies", cookies)
+	router.Get("/cookies/set", setCookies)
+	router.Get("/cookies/delete", deleteCookies)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "abc123"})
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		return rr
+	}
+
+	rr := get("/cookies")
+	list := CookieList{}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || list.Cookies["session"] != "abc123" {
+		t.Errorf("expected session cookie to be echoed, got %s", rr.Body.String())
+	}
+
+	rr = get("/cookies/set?theme=dark&secure&httpOnly=true&sameSite=None&maxAge=60&partitioned&domain=example.com")
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/cookies" {
+		t.Errorf("expected redirect to /cookies, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	setCookie := rr.Header().Get("Set-Cookie")
+	for _, part := range []string{"theme=dark", "Path=/", "Domain=example.com", "Max-Age=60", "HttpOnly", "Secure",
+		"SameSite=None", "Partitioned"} {
+		if !strings.Contains(setCookie, part) {
+			t.Errorf("expected Set-Cookie to include %s, got %s", part, setCookie)
+		}
+	}
+
+	if cookies := rr.Result().Cookies(); len(cookies) != 1 {
+		t.Errorf("expected control parameters not to be set as cookies, got %d cookies", len(cookies))
+	}
+
+	rr = get("/cookies/delete?session&path=/app")
+	if setCookie := rr.Header().Get("Set-Cookie"); !strings.Contains(setCookie, "session=;") ||
+		!strings.Contains(setCookie, "Max-Age=0") || !strings.Contains(setCookie, "Path=/app") {
+		t.Errorf("expected session cookie to be expired, got %s", setCookie)
+	}
+
+	for _, url := range []string{"/cookies/set?a=1&sameSite=sometimes", "/cookies/set?a=1&maxAge=-5",
+		"/cookies/set?a=1&secure=maybe", "/cookies/set?a=1&bad%20name=2", "/cookies/delete?bad%20name"} {
+		if rr := get(url); rr.Code != http.StatusBadRequest || rr.Header().Get("Set-Cookie") != "" {
+			t.Errorf("expected %s to be rejected without setting cookies, got %d", url, rr.Code)
+		}
+	}
+}
+
+func TestCookiesRedacted(t *testing.T) {
+	defer func() { redactor = nil }()
+
+	redactor, _ = redact.New(nil, nil, []string{`acct-\d+`})
+
+	req := httptest.NewRequest(http.MethodGet, "/cookies", nil)
+	req.Header.Set("Cookie", "JSESSIONID=abc123; AWSALB=node-2; account=acct-42; theme=dark")
+
+	rr := httptest.NewRecorder()
+	cookies(rr, req)
+
+	list := CookieList{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &list)
+
+	want := map[string]string{
+		"JSESSIONID": redact.Marker, "AWSALB": "node-2", "account": redact.Marker, "theme": "dark",
+	}
+	for name, value := range want {
+		if list.Cookies[name] != value {
+			t.Errorf("expected cookie %s to be %s, got %s", name, value, list.Cookies[name])
+		}
+	}
+}
+
+func TestHistoryReplay(t *testing.T) {
+	cfg = NewConfig()
+	requestHistory = history.NewStore(10)
+	defer func() { requestHistory = nil }()
+
+	var received *http.Request
+
+	var receivedBody string
+
+	// Target which compresses its response with whatever encoding it's asked for
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received, receivedBody = r, string(body)
+
+		var cw io.WriteCloser
+
+		switch r.Header.Get("Accept-Encoding") {
+		case "br":
+			w.Header().Set("Content-Encoding", "br")
+			cw = brotli.NewWriter(w)
+		case "gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			cw = gzip.NewWriter(w)
+		default:
+			_, _ = w.Write([]byte("replayed"))
+			return
+		}
+
+		_, _ = cw.Write([]byte("replayed"))
+		_ = cw.Close()
+	}))
+	defer target.Close()
+
+	captured := httptest.NewRequest(http.MethodPut, "/orders?id=7", strings.NewReader(`{"qty":2}`))
+	captured.Header.Set("Content-Type", "application/json")
+	captured.Header.Set("X-Custom", "yes")
+	captured.Header.Set("Accept-Encoding", "br")
+	captured.Header.Set("Connection", "keep-alive, X-Hop")
+	captured.Header.Set("X-Hop", "1")
+	captured.Header.Set("Upgrade", "h2c")
+
+	rd := inspectRequest(captured)
+	requestHistory.Add(rd)
+
+	req := httptest.NewRequest(http.MethodPost, "/history/"+rd.ID+"/replay?target="+target.URL, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", rd.ID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	historyReplay(rr, req)
+
+	result := ReplayResult{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil || result.Response == nil {
+		t.Fatalf("unexpected replay result: %s", rr.Body.String())
+	}
+
+	if received.Method != http.MethodPut || received.URL.RequestURI() != "/orders?id=7" || receivedBody != `{"qty":2}` {
+		t.Errorf("unexpected replayed request: %s %s %s", received.Method, received.URL, receivedBody)
+	}
+
+	if received.Header.Get("X-Custom") != "yes" || received.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected captured headers to be replayed, got %v", received.Header)
+	}
+
+	if received.Header.Get("X-Hop") != "" || received.Header.Get("Upgrade") != "" {
+		t.Errorf("expected hop-by-hop headers to be dropped, got %v", received.Header)
+	}
+
+	// The transport asked for gzip itself, so the body comes back decompressed
+	if result.Response.Body != "replayed" || received.Header.Get("Accept-Encoding") != "gzip" {
+		t.Errorf("expected decompressed body, got %q with Accept-Encoding %s", result.Response.Body,
+			received.Header.Get("Accept-Encoding"))
+	}
+}
+
+func TestHistoryReplayTruncated(t *testing.T) {
+	cfg = NewConfig()
+	requestHistory = history.NewStore(10)
+	defer func() { requestHistory = nil }()
+
+	rd := httputil.RequestDetails{ID: "cut", Method: http.MethodPost, Path: "/upload", Body: "part", BodyTruncated: true}
+	requestHistory.Add(rd)
+
+	req := httptest.NewRequest(http.MethodPost, "/history/cut/replay?target=http://localhost:1", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "cut")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	rr := httptest.NewRecorder()
+	historyReplay(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "truncated") {
+		t.Errorf("expected truncated request to be refused, got %d %s", rr.Code, rr.Body.String())
+	}
+}
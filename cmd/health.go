@@ -0,0 +1,164 @@
+package main
+
+// ==== http-toolkit: health.go =======================================================================================
+// Liveness, readiness & startup probes, plus the admin API used to make them fail on demand
+// ====================================================================================================================
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/benc-uk/http-toolkit/pkg/probe"
+	"github.com/go-chi/chi/v5"
+)
+
+// Names of the probes, in the order they are listed by the admin API
+var probeNames = []string{"live", "ready", "startup"}
+
+// Probes which can be made to fail through the admin API
+var probes = map[string]*probe.Probe{
+	"live":    probe.New("live"),
+	"ready":   probe.New("ready"),
+	"startup": probe.New("startup"),
+}
+
+func liveness(w http.ResponseWriter, r *http.Request) {
+	checkProbe(w, r, probes["live"])
+}
+
+// Readiness also starts failing as soon as shutdown begins, so load balancers stop sending traffic
+func ready(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("Shutting down"))
+
+		return
+	}
+
+	checkProbe(w, r, probes["ready"])
+}
+
+func startup(w http.ResponseWriter, r *http.Request) {
+	checkProbe(w, r, probes["startup"])
+}
+
+func checkProbe(w http.ResponseWriter, r *http.Request, p *probe.Probe) {
+	status := p.Check()
+	if status == http.StatusOK {
+		ok(w, r)
+		return
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(http.StatusText(status)))
+}
+
+// Make the startup & readiness probes fail for a while, as if the app was slow to start
+func delayStartup(delay time.Duration) {
+	probes["startup"].Fail(probe.Failure{Duration: delay})
+	probes["ready"].Fail(probe.Failure{Duration: delay})
+}
+
+// healthStates lists the current state of all probes
+func healthStates(w http.ResponseWriter, r *http.Request) {
+	states := []probe.State{}
+	for _, name := range probeNames {
+		states = append(states, probes[name].State())
+	}
+
+	writeJSON(w, http.StatusOK, states)
+}
+
+// healthFail makes a probe fail, options are passed as query parameters:
+// - duration: how long to fail for e.g. 30s or 2m, a plain number is seconds, default is until reset
+// - every: only fail every Nth check
+// - status: status code failing checks return, default is 503
+func healthFail(w http.ResponseWriter, r *http.Request) {
+	p, found := probes[chi.URLParam(r, "probe")]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Probe not found"))
+
+		return
+	}
+
+	failure, err := parseFailure(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Invalid options: " + err.Error()))
+
+		return
+	}
+
+	p.Fail(failure)
+	writeJSON(w, http.StatusOK, p.State())
+}
+
+// healthReset makes a probe pass again
+func healthReset(w http.ResponseWriter, r *http.Request) {
+	p, found := probes[chi.URLParam(r, "probe")]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Probe not found"))
+
+		return
+	}
+
+	p.Reset()
+	writeJSON(w, http.StatusOK, p.State())
+}
+
+func parseFailure(r *http.Request) (probe.Failure, error) {
+	failure := probe.Failure{}
+	query := r.URL.Query()
+
+	if duration := query.Get("duration"); duration != "" {
+		d, err := parseDuration(duration)
+		if err != nil {
+			return failure, errors.New("duration must be a number of seconds or a value like 30s or 2m")
+		}
+
+		failure.Duration = d
+	}
+
+	if every := query.Get("every"); every != "" {
+		n, err := strconv.Atoi(every)
+		if err != nil || n < 1 {
+			return failure, errors.New("every must be a number, 1 or more")
+		}
+
+		failure.Every = n
+	}
+
+	if status := query.Get("status"); status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 400 || code > 599 {
+			return failure, errors.New("status must be between 400 and 599")
+		}
+
+		failure.Status = code
+	}
+
+	return failure, nil
+}
+
+// Parse a duration such as 500ms or 2m, a plain number is taken as seconds
+func parseDuration(value string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		value = fmt.Sprintf("%gs", secs)
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if d < 0 {
+		return 0, errors.New("duration can not be negative")
+	}
+
+	return d, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+// ==== http-toolkit: history.go ======================================================================================
+// Handlers for the /history API, letting you look back at requests captured by the inspector
+// ====================================================================================================================
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/benc-uk/http-toolkit/pkg/history"
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// Holds recently inspected requests, will be nil when history is disabled
+var requestHistory *history.Store
+
+// Number of rotated capture files to keep alongside the current one
+const captureBackups = 3
+
+// Response returned when listing the history
+type HistoryList struct {
+	Count    int                       `json:"count"`
+	Size     int                       `json:"size"`
+	Requests []httputil.RequestDetails `json:"requests"`
+}
+
+func historyList(w http.ResponseWriter, r *http.Request) {
+	requests := requestHistory.List(history.NewFilter(r.URL.Query()))
+
+	limit := r.URL.Query().Get("limit")
+	if limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil || limitInt < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("Invalid limit value"))
+
+			return
+		}
+
+		if limitInt < len(requests) {
+			requests = requests[:limitInt]
+		}
+	}
+
+	writeJSON(w, http.StatusOK, HistoryList{
+		Count:    len(requests),
+		Size:     requestHistory.Size(),
+		Requests: formatAll(r, requests),
+	})
+}
+
+func historyGet(w http.ResponseWriter, r *http.Request) {
+	rd, found := findRequest(r)
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Request not found in history"))
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rd.Format(outputVersion(r)))
+}
+
+// historyExport renders a captured request as a curl command, HAR or .http file
+// The URL in the output will be the original URL of the request, unless a different ?target= is given
+func historyExport(w http.ResponseWriter, r *http.Request) {
+	rd, found := findRequest(r)
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Request not found in history"))
+
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	if target == "" && rd.Host != "" {
+		target = rd.Scheme + "://" + rd.Host
+	}
+
+	// Requests captured by older versions don't have the host, so fall back to this server
+	if target == "" {
+		target = baseURL(r)
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "curl":
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(rd.ToCurl(target)))
+	case "http":
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(rd.ToHTTPFile(target)))
+	case "har":
+		writeJSON(w, http.StatusOK, httputil.NewHAR(version, target, rd))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Invalid format, must be one of: curl, har, http"))
+	}
+}
+
+func historyClear(w http.ResponseWriter, r *http.Request) {
+	requestHistory.Clear()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Find a request in the history by the {id} URL param, or in a bin if ?bin= is set
+func findRequest(r *http.Request) (httputil.RequestDetails, bool) {
+	store := requestHistory
+
+	if binID := r.URL.Query().Get("bin"); binID != "" {
+		bin, found := requestBins.Get(binID)
+		if !found {
+			return httputil.RequestDetails{}, false
+		}
+
+		store = bin.Requests
+	}
+
+	return store.Get(chi.URLParam(r, "id"))
+}
+
+// Scheme and host this request was sent to, e.g. http://localhost:8000
+func baseURL(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+
+	return "http://" + r.Host
+}
+
+// Helper to write a value as an indented JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// Allow WebSocket connections from any origin, this is a debugging tool after all
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// How often to send keep-alives on streams, so idle connections aren't closed by proxies
+const streamKeepAlive = 15 * time.Second
+
+// historyStream pushes requests as they are captured, using WebSocket if requested or SSE otherwise
+// Pass ?bin={id} to stream a bin rather than the main history, the usual filters are supported too
+func historyStream(w http.ResponseWriter, r *http.Request) {
+	store := requestHistory
+
+	if binID := r.URL.Query().Get("bin"); binID != "" {
+		bin, found := requestBins.Get(binID)
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("Bin not found"))
+
+			return
+		}
+
+		store = bin.Requests
+	}
+
+	filter := history.NewFilter(r.URL.Query())
+
+	if websocket.IsWebSocketUpgrade(r) {
+		streamWebSocket(w, r, store, filter)
+		return
+	}
+
+	streamSSE(w, r, store, filter)
+}
+
+func streamSSE(w http.ResponseWriter, r *http.Request, store *history.Store, filter history.Filter) {
+	rc := http.NewResponseController(w)
+
+	// Streams are long lived, so remove the server write timeout for this response
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = w.Write([]byte(": connected\n\n"))
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	sub, unsubscribe := store.Subscribe()
+	defer unsubscribe()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		// End the stream so it doesn't hold up draining, clients will reconnect to another instance
+		case <-shutdownCh:
+			return
+
+		case <-keepAlive.C:
+			_, _ = w.Write([]byte(": keep-alive\n\n"))
+
+		case rd := <-sub:
+			if !filter.Match(rd) {
+				continue
+			}
+
+			data, err := json.Marshal(rd.Format(outputVersion(r)))
+			if err != nil {
+				continue
+			}
+
+			_, _ = fmt.Fprintf(w, "id: %s\nevent: request\ndata: %s\n\n", rd.ID, data)
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func streamWebSocket(w http.ResponseWriter, r *http.Request, store *history.Store, filter history.Filter) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+	defer conn.Close()
+
+	sub, unsubscribe := store.Subscribe()
+	defer unsubscribe()
+
+	// We never expect messages from the client, but must read to notice when it goes away
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		// Hijacked connections aren't closed by the server on shutdown, so say goodbye properly
+		case <-shutdownCh:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server shutting down")
+			_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+
+			return
+
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamKeepAlive)); err != nil {
+				return
+			}
+
+		case rd := <-sub:
+			if !filter.Match(rd) {
+				continue
+			}
+
+			if err := conn.WriteJSON(rd.Format(outputVersion(r))); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// enableCapture loads previously captured requests from the capture file into the history
+// and then persists all new requests to it, if this fails history is kept in memory only
+func enableCapture() {
+	loaded, err := history.LoadFile(cfg.captureFile, captureBackups)
+	if err != nil {
+		slog.Warn("😟 Problem loading capture file", "error", err)
+	}
+
+	for _, rd := range loaded {
+		requestHistory.Add(rd)
+	}
+
+	sink, err := history.NewFileSink(cfg.captureFile, int64(cfg.captureMaxSize)*1024*1024, captureBackups)
+	if err != nil {
+		slog.Warn("😟 Unable to open capture file, history will not be persisted", "error", err)
+		return
+	}
+
+	requestHistory.SetSink(sink)
+	captureSink = sink
+
+	slog.Info("💾 Capturing requests", "file", cfg.captureFile, "loaded", len(loaded))
+}
+
+// The capture file being written to, if any, kept so it can be closed on shutdown
+var captureSink *history.FileSink
+
+// closeCapture stops persisting requests and closes the capture file
+func closeCapture() {
+	if captureSink == nil {
+		return
+	}
+
+	requestHistory.SetSink(nil)
+
+	if err := captureSink.Close(); err != nil {
+		slog.Warn("😟 Problem closing capture file", "error", err)
+	}
+
+	captureSink = nil
+}
@@ -0,0 +1,96 @@
+package main
+
+// ==== http-toolkit: logging.go ======================================================================================
+// Structured logging setup, and the access log middleware which writes one record per request
+// ====================================================================================================================
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Set up the default slog logger, this also captures anything written with the standard log package
+func setupLogging(format string, level string) error {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level: %s", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler
+
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	default:
+		return fmt.Errorf("invalid log format: %s", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+
+	return nil
+}
+
+// Log an error and exit, the slog equivalent of log.Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Middleware which logs a structured record for each request once it has been handled
+// Must be used after middleware.RequestID so every record has a request ID
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		reqID := middleware.GetReqID(r.Context())
+
+		// Hand the ID back, so clients can find the log records for their request
+		w.Header().Set(middleware.RequestIDHeader, reqID)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			// Handlers which never call WriteHeader have implicitly sent a 200
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			uri := r.RequestURI
+			if redactor != nil {
+				uri = redactor.URI(uri)
+			}
+
+			attrs := []any{
+				"requestId", reqID,
+				"method", r.Method,
+				"uri", uri,
+				"proto", r.Proto,
+				"status", status,
+				"bytes", ww.BytesWritten(),
+				"durationMs", msSince(start),
+				"remoteAddr", r.RemoteAddr,
+				"userAgent", r.UserAgent(),
+			}
+
+			// Link the record to the trace when tracing is enabled
+			if spanCtx := trace.SpanContextFromContext(r.Context()); spanCtx.IsValid() {
+				attrs = append(attrs, "traceId", spanCtx.TraceID().String(), "spanId", spanCtx.SpanID().String())
+			}
+
+			slog.Info("Request", attrs...)
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
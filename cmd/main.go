@@ -6,20 +6,26 @@ package main
 // ====================================================================================================================
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
-	"github.com/benc-uk/http-toolkit/pkg/httputil"
+	"github.com/benc-uk/http-toolkit/pkg/chaos"
+	"github.com/benc-uk/http-toolkit/pkg/compress"
+	"github.com/benc-uk/http-toolkit/pkg/history"
+	"github.com/benc-uk/http-toolkit/pkg/redact"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/jwtauth/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var cfg Config
 var tokenAuth *jwtauth.JWTAuth
+var redactor *redact.Redactor
 var version = "0.0"
 
 func main() {
@@ -28,33 +34,124 @@ func main() {
 	cfg.loadFlags()
 	cfg.loadEnv()
 
-	log.Printf("🌐 HTTP Toolkit %s", version)
+	if err := setupLogging(cfg.logFormat, cfg.logLevel); err != nil {
+		fatal("💥 Unable to configure logging", "error", err)
+	}
+
+	slog.Info("🌐 HTTP Toolkit", "version", version)
+	cfg.flushLogs()
+
+	var err error
+
+	redactor, err = cfg.newRedactor()
+	if err != nil {
+		fatal("💥 Unable to configure redaction", "error", err)
+	}
+
+	if redactor != nil {
+		slog.Info("🙈 Redacting sensitive values from inspected requests")
+	}
+
+	// The admin API is excluded, so chaos can always be turned off again
+	chaosEngine = chaos.New(cfg.routePrefix + "admin")
+	chaosEngine.SetMaxLatency(time.Duration(cfg.maxDelay) * time.Second)
+
+	if err := chaosEngine.Update(cfg.chaosSettings()); err != nil {
+		fatal("💥 Unable to configure chaos", "error", err)
+	}
+
+	if settings := chaosEngine.Settings(); settings.Enabled() {
+		slog.Info("🐒 Chaos enabled", "settings", settings)
+	}
+
+	shutdownTracing, err := setupTracing(cfg.tracing)
+	if err != nil {
+		fatal("💥 Unable to configure tracing", "error", err)
+	}
 
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
+	r.Use(accessLog)
+
+	var handler http.Handler = r
+
+	if cfg.tracing != "none" && cfg.tracing != "" {
+		r.Use(tracingRouteMiddleware)
+
+		handler = tracingHandler(r)
+
+		slog.Info("🔭 Tracing enabled", "exporter", cfg.tracing)
+	}
+
+	// Compression goes after chaos, so truncated responses are cut short after they have been compressed
+	compression := func(next http.Handler) http.Handler { return next }
+
+	if cfg.compression {
+		compression = compress.New(cfg.compressOptions()).Middleware
+		slog.Info("🗜️ Compressing responses", "minSize", cfg.compressMinSize)
+	}
 
 	// Check for static serving modes
 	if cfg.staticPath != "" {
+		r.Use(chaosEngine.Middleware)
+		r.Use(compression)
+
 		// Serve SPA static files with client-side routing support
 		r.Get(cfg.routePrefix+"*", staticServe)
-		log.Printf("📁 Serving static files from: %s", cfg.staticPath)
+		slog.Info("📁 Serving static files", "path", cfg.staticPath)
 	} else if cfg.spaPath != "" {
+		r.Use(chaosEngine.Middleware)
+		r.Use(compression)
+
 		// Serve static files like an old fashioned web server
 		r.Get(cfg.routePrefix+"*", spaServe)
-		log.Printf("📁 Serving SPA from: %s", cfg.spaPath)
+		slog.Info("📁 Serving SPA", "path", cfg.spaPath)
 	} else {
 		// Otherwise, we run the normal debugger & API
+		if cfg.metrics {
+			r.Use(metricsMiddleware(r))
+		}
+
+		// After metrics, so requests with chaos injected are counted, see chaosInjected
+		r.Use(chaosEngine.Middleware)
+		r.Use(compression)
+
 		if cfg.reqDebug {
 			r.Use(reqDebugMiddleware)
 		}
 
+		if cfg.historySize > 0 {
+			requestHistory = history.NewStore(cfg.historySize)
+			requestBins = history.NewBins(maxBins, cfg.historySize)
+			slog.Info("📜 Keeping history of inspected requests", "size", cfg.historySize)
+
+			if cfg.captureFile != "" {
+				enableCapture()
+			}
+		}
+
+		if cfg.startupDelay > 0 {
+			startupDelay := time.Duration(cfg.startupDelay) * time.Second
+
+			delayStartup(startupDelay)
+			slog.Info("⏳ Startup & readiness probes will fail until the startup delay has passed", "delay", startupDelay)
+		}
+
 		// Add all routes under a sub-router
 		// This allows a custom prefix for all routes
 		r.Route(cfg.routePrefix, func(r chi.Router) {
 			r.Get("/", ok)
 			r.Get("/health*", ok)
+			r.Get("/health/live", liveness)
+			r.Get("/health/ready", ready)
+			r.Get("/health/startup", startup)
+			r.Get("/ready", ready)
 			r.Get("/info", systemInfo)
 
+			if cfg.metrics {
+				r.Handle("/metrics", promhttp.Handler())
+			}
+
 			r.HandleFunc("/status/{code}", statusCode)
 			r.Get("/word", randomWord)
 			r.Get("/word/{count}", randomWord)
@@ -66,13 +163,38 @@ func main() {
 			r.HandleFunc("/delay/{seconds}", delay)
 			r.HandleFunc("/delay", delay)
 
+			r.Get("/stream/{n}", streamLines)
+			r.Get("/drip", drip)
+			r.Get("/chunked", chunked)
+			r.Get("/sse/{n}", serverSentEvents)
+
+			r.Get("/bytes/{n}", randomBytes)
+			r.Get("/range/{n}", rangeBytes)
+			r.Get("/stream-bytes/{n}", streamBytes)
+
+			r.Get("/json", sample("sample.json", "application/json"))
+			r.Get("/xml", sample("sample.xml", "application/xml"))
+			r.Get("/html", sample("sample.html", "text/html; charset=utf-8"))
+			r.Get("/robots.txt", sample("robots.txt", "text/plain"))
+			r.Get("/encoding/utf8", sample("utf8.txt", "text/plain; charset=utf-8"))
+			r.Get("/image/{format}", sampleImage)
+
+			r.Get("/gzip", compressed("gzip"))
+			r.Get("/deflate", compressed("deflate"))
+			r.Get("/brotli", compressed("br"))
+
+			r.Get("/cookies", cookies)
+			r.Get("/cookies/set", setCookies)
+			r.Get("/cookies/delete", deleteCookies)
+
 			// Route protected by basic auth
 			r.Route("/auth/basic", func(subRouter chi.Router) {
+				subRouter.Use(countAuthFailures("basic"))
 				subRouter.Use(middleware.BasicAuth("realm", map[string]string{
 					cfg.basicAuthUser: cfg.basicAuthPassword,
 				}))
 
-				log.Printf("🔐 Basic auth credentials: %s:%s\n", cfg.basicAuthUser, cfg.basicAuthPassword)
+				slog.Info("🔐 Basic auth credentials", "user", cfg.basicAuthUser, "password", cfg.basicAuthPassword)
 
 				subRouter.HandleFunc("/", ok)
 			})
@@ -81,16 +203,48 @@ func main() {
 			r.Route("/auth/jwt", func(subRouter chi.Router) {
 				tokenAuth = jwtauth.New("HS256", []byte(cfg.jwtSignKey), nil)
 
+				subRouter.Use(countAuthFailures("jwt"))
 				subRouter.Use(jwtauth.Verifier(tokenAuth))
 				subRouter.Use(jwtauth.Authenticator(tokenAuth))
 
 				// Generate a valid JWT token for testing with no claims
 				_, exampleToken, _ := tokenAuth.Encode(map[string]interface{}{})
-				log.Printf("🔑 JWT valid token: %s\n", exampleToken)
+				slog.Info("🔑 JWT valid token", "token", exampleToken)
 
 				subRouter.HandleFunc("/", ok)
 			})
 
+			// View and clear the history of inspected requests
+			if requestHistory != nil {
+				r.Route("/history", func(subRouter chi.Router) {
+					subRouter.Get("/", historyList)
+					subRouter.Delete("/", historyClear)
+					subRouter.Get("/stream", historyStream)
+					subRouter.Get("/{id}", historyGet)
+					subRouter.Get("/{id}/export", historyExport)
+					subRouter.Post("/{id}/replay", historyReplay)
+				})
+
+				// Named bins, each capturing requests into their own history
+				r.Route("/bins", func(subRouter chi.Router) {
+					subRouter.Post("/", binCreate)
+					subRouter.Get("/", binList)
+					subRouter.Get("/{id}", binGet)
+					subRouter.Delete("/{id}", binDelete)
+					subRouter.HandleFunc("/{id}/*", binCapture)
+				})
+			}
+
+			// Admin API to change the behaviour of the server at runtime
+			r.Route("/admin", func(subRouter chi.Router) {
+				subRouter.Get("/health", healthStates)
+				subRouter.Post("/health/{probe}", healthFail)
+				subRouter.Delete("/health/{probe}", healthReset)
+				subRouter.Get("/chaos", chaosGet)
+				subRouter.Post("/chaos", chaosUpdate)
+				subRouter.Delete("/chaos", chaosReset)
+			})
+
 			// Serve the Swagger UI from the /docs route
 			r.Get("/docs/*", docsServe)
 			r.Get("/docs", func(w http.ResponseWriter, r *http.Request) {
@@ -114,41 +268,68 @@ func main() {
 		ReadHeaderTimeout: 30 * time.Second,
 		WriteTimeout:      30 * time.Second,
 		IdleTimeout:       120 * time.Second,
-		Handler:           r,
+		Handler:           handler,
 	}
 
-	log.Printf("📂 Route prefix: %s", cfg.routePrefix)
+	slog.Info("📂 Route prefix", "prefix", cfg.routePrefix)
+
+	listen := server.ListenAndServe
 
 	// Start the server using TLS if configured
 	if cfg.useTLS {
-		log.Printf("🚀 Server started with TLS on port %s", cfg.port)
+		tlsConfig, err := cfg.tlsConfig()
+		if err != nil {
+			fatal("💥 Unable to configure TLS", "error", err)
+		}
+
+		if tlsConfig.ClientAuth != tls.NoClientCert {
+			slog.Info("🪪 Client certificates (mTLS) enabled", "mode", cfg.clientAuth)
+		}
+
+		slog.Info("🚀 Server started with TLS", "port", cfg.port)
+
+		server.TLSConfig = tlsConfig
 
-		server.TLSConfig = &tls.Config{
-			MinVersion: tls.VersionTLS12,
+		listen = func() error {
+			return server.ListenAndServeTLS(cfg.certPath+"/cert.pem", cfg.certPath+"/key.pem")
 		}
+	} else {
+		slog.Info("🚀 Server started", "port", cfg.port)
+	}
+
+	// Blocks until the server fails or has been shut down & drained
+	err = runServer(server, listen)
+
+	// Flush anything still buffered before exiting
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if tracingErr := shutdownTracing(flushCtx); tracingErr != nil {
+		slog.Warn("😟 Unable to flush traces", "error", tracingErr)
+	}
+
+	closeCapture()
 
-		// ListenAndServeTLS blocks so nothing after this will run
-		log.Fatal(server.ListenAndServeTLS(cfg.certPath+"/cert.pem", cfg.certPath+"/key.pem"))
+	if err != nil {
+		fatal("💥 Server stopped", "error", err)
 	}
 
-	// Otherwise start the server without TLS
-	log.Printf("🚀 Server started on port %s", cfg.port)
-	log.Fatal(server.ListenAndServe())
+	slog.Info("👋 Server stopped")
 }
 
 // Middleware to log 'deep' request details to the console
 func reqDebugMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Debug requests to JSON string and log to console
-		reqDetails := httputil.NewRequestDetails(r, cfg.bodyDebug)
+		// Debug requests to JSON and log to console, as a single record so log collectors keep it together
+		reqDetails := inspectRequest(r)
 
-		reqJSON, err := json.MarshalIndent(reqDetails, "", "  ")
+		reqJSON, err := json.Marshal(reqDetails.Format(cfg.inspectVersion))
 		if err != nil {
-			log.Println(err)
+			slog.Error("Unable to encode request details", "error", err)
 		}
 
-		log.Println("Request details:", string(reqJSON))
+		slog.Info("Request details", "requestId", middleware.GetReqID(r.Context()), "request", json.RawMessage(reqJSON))
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withRequestDetails(r, reqDetails))
 	})
 }
@@ -0,0 +1,141 @@
+package main
+
+// ==== http-toolkit: metrics.go ======================================================================================
+// Prometheus metrics for all requests, plus counters for things specific to the toolkit
+// ====================================================================================================================
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Route label used for requests which don't match any route
+const unmatchedRoute = "unmatched"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests by method, route pattern & status code",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "http_request_duration_seconds",
+		Help: "Duration of HTTP requests by method, route pattern & status code",
+		// Default buckets stop at 10s, but delays can go much higher
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"method", "route", "status"})
+
+	httpInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "Number of HTTP requests currently being handled by method & route pattern",
+	}, []string{"method", "route"})
+
+	delaysServed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "http_toolkit_delays_total",
+		Help: "Total number of delayed responses served",
+	})
+
+	delaySeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "http_toolkit_delay_seconds_total",
+		Help: "Total time spent in delays served",
+	})
+
+	forcedStatus = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_toolkit_forced_status_total",
+		Help: "Total number of responses with a status code forced via /status/{code}",
+	}, []string{"code"})
+
+	authFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_toolkit_auth_failures_total",
+		Help: "Total number of failed authentication attempts by auth type",
+	}, []string{"type"})
+
+	chaosInjected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_toolkit_chaos_injected_total",
+		Help: "Total number of requests with chaos injected by type, one of: latency, reset, error or truncate",
+	}, []string{"type"})
+)
+
+// Middleware which records request metrics, labelled with the chi route pattern rather than the path
+// to keep cardinality down. The router is needed to find the pattern before the request has been routed
+func metricsMiddleware(router chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := unmatchedRoute
+
+			rctx := chi.NewRouteContext()
+			if router.Match(rctx, r.Method, r.URL.Path) {
+				route = rctx.RoutePattern()
+			}
+
+			method := methodLabel(r.Method)
+
+			inFlight := httpInFlight.WithLabelValues(method, route)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			defer func() {
+				status := strconv.Itoa(http.StatusOK)
+				if ww.Status() != 0 {
+					status = strconv.Itoa(ww.Status())
+				}
+
+				// The chaos middleware runs inside this one & says what it did with headers, which are never sent
+				// for a reset. Resets & truncates abort the handler, but deferred calls still run so they're counted
+				if ww.Header().Get("X-Chaos-Latency") != "" {
+					chaosInjected.WithLabelValues("latency").Inc()
+				}
+
+				if chaosType := ww.Header().Get("X-Chaos"); chaosType != "" {
+					chaosInjected.WithLabelValues(chaosType).Inc()
+
+					// No response was sent at all, so there's no status
+					if chaosType == "reset" {
+						status = "reset"
+					}
+				}
+
+				labels := []string{method, route, status}
+				httpRequests.WithLabelValues(labels...).Inc()
+				httpDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// Standard methods are labelled as they are, clients can send any method so everything else is lumped together
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+
+	return "other"
+}
+
+// Middleware which counts responses with a 401 status as auth failures of the given type
+func countAuthFailures(authType string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			if ww.Status() == http.StatusUnauthorized {
+				authFailures.WithLabelValues(authType).Inc()
+			}
+		})
+	}
+}
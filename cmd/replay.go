@@ -0,0 +1,219 @@
+package main
+
+// ==== http-toolkit: replay.go =======================================================================================
+// Handler to replay a request captured in the history, sending it on to another URL
+// ====================================================================================================================
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+)
+
+// Don't hang around forever waiting for the target, and stay under the server write timeout
+const replayTimeout = 25 * time.Second
+
+// Cap on how much of the upstream response body is returned
+const maxReplayBody = 10 * 1024 * 1024
+
+// Hop-by-hop headers only apply to the connection the request was captured on, so they aren't replayed
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate", "Proxy-Authorization", "Te", "Trailer",
+	"Transfer-Encoding", "Upgrade",
+}
+
+var replayClient = &http.Client{
+	Timeout: replayTimeout,
+	// We want to see exactly what the target returned, so don't follow redirects
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Result of replaying a request, returned as JSON
+type ReplayResult struct {
+	Request  ReplayRequest   `json:"request"`
+	Response *ReplayResponse `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Timing   ReplayTiming    `json:"timing"`
+}
+
+type ReplayRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type ReplayResponse struct {
+	Status        int               `json:"status"`
+	StatusText    string            `json:"statusText"`
+	Headers       map[string]string `json:"headers"`
+	Body          string            `json:"body,omitempty"`
+	BodyTruncated bool              `json:"bodyTruncated,omitempty"`
+}
+
+// All timings are in milliseconds, phases which didn't happen (e.g. TLS for plain HTTP) are zero
+type ReplayTiming struct {
+	DNS       float64 `json:"dnsMs"`
+	Connect   float64 `json:"connectMs"`
+	TLS       float64 `json:"tlsMs"`
+	FirstByte float64 `json:"firstByteMs"`
+	Total     float64 `json:"totalMs"`
+}
+
+// historyReplay sends a captured request to the URL given in ?target= and returns what came back
+func historyReplay(w http.ResponseWriter, r *http.Request) {
+	rd, found := findRequest(r)
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Request not found in history"))
+
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+
+	targetURL, err := url.Parse(target)
+	if err != nil || (targetURL.Scheme != "http" && targetURL.Scheme != "https") || targetURL.Host == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Invalid or missing target, must be a http or https URL"))
+
+		return
+	}
+
+	req, err := rd.ToRequest(r.Context(), target)
+	if errors.Is(err, httputil.ErrBodyTruncated) {
+		// Sending only part of the body would be a different request to the one captured
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte("Unable to replay request, the " + err.Error()))
+
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("Unable to create request: " + err.Error()))
+
+		return
+	}
+
+	removeReplayHeaders(req.Header, rd.AllHeaders()["Connection"])
+
+	result := ReplayResult{
+		Request: ReplayRequest{Method: req.Method, URL: req.URL.String()},
+	}
+
+	start := time.Now()
+	timing := &replayTimer{start: start}
+
+	resp, err := replayClient.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), timing.trace())))
+	if err != nil {
+		result.Error = err.Error()
+		result.Timing = timing.result()
+		writeJSON(w, http.StatusBadGateway, result)
+
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxReplayBody+1))
+	if err != nil {
+		result.Error = "Error reading response body: " + err.Error()
+	}
+
+	result.Timing = timing.result()
+
+	headers := make(map[string]string)
+	for k, v := range resp.Header {
+		headers[k] = strings.Join(v, ",")
+	}
+
+	result.Response = &ReplayResponse{
+		Status:     resp.StatusCode,
+		StatusText: http.StatusText(resp.StatusCode),
+		Headers:    headers,
+		Body:       string(body),
+	}
+
+	if len(body) > maxReplayBody {
+		result.Response.Body = string(body[:maxReplayBody])
+		result.Response.BodyTruncated = true
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Remove captured headers which shouldn't be sent again, hop-by-hop ones & any named in the Connection header
+func removeReplayHeaders(header http.Header, connection []string) {
+	for _, value := range connection {
+		for _, name := range strings.Split(value, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+
+	// Leaving the captured Accept-Encoding means the transport won't decompress the response, and the body would come
+	// back as raw gzip or brotli. Without it the transport asks for gzip itself & decompresses it transparently
+	header.Del("Accept-Encoding")
+}
+
+// Records the timing of each phase of a replay
+// Trace callbacks run on transport goroutines & can fire after Do returns, e.g. for a dial that lost a race, so
+// everything is guarded by a mutex
+type replayTimer struct {
+	mu                               sync.Mutex
+	start                            time.Time
+	dnsStart, connectStart, tlsStart time.Time
+	timing                           ReplayTiming
+}
+
+func (t *replayTimer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.record(func() { t.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.record(func() { t.timing.DNS = msSince(t.dnsStart) }) },
+		ConnectStart: func(string, string) {
+			t.record(func() { t.connectStart = time.Now() })
+		},
+		ConnectDone: func(string, string, error) {
+			t.record(func() { t.timing.Connect = msSince(t.connectStart) })
+		},
+		TLSHandshakeStart: func() { t.record(func() { t.tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.record(func() { t.timing.TLS = msSince(t.tlsStart) })
+		},
+		GotFirstResponseByte: func() { t.record(func() { t.timing.FirstByte = msSince(t.start) }) },
+	}
+}
+
+func (t *replayTimer) record(update func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	update()
+}
+
+// A copy of the timings so far, with the total taken now
+func (t *replayTimer) result() ReplayTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timing := t.timing
+	timing.Total = msSince(t.start)
+
+	return timing
+}
+
+// Milliseconds elapsed since t, as a float so we keep sub-millisecond precision
+func msSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
@@ -0,0 +1,83 @@
+package main
+
+// ==== http-toolkit: shutdown.go =====================================================================================
+// Graceful shutdown, draining in-flight requests on SIGTERM so rolling updates can be tested properly
+// ====================================================================================================================
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Closed as soon as shutdown begins, long lived streams watch this so they don't hold up draining
+var shutdownCh = make(chan struct{})
+var shutdownOnce sync.Once
+
+func beginShutdown() {
+	shutdownOnce.Do(func() { close(shutdownCh) })
+}
+
+func shuttingDown() bool {
+	select {
+	case <-shutdownCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run the server until it fails or a SIGTERM or SIGINT is received, then shut it down gracefully:
+// - Readiness starts failing straight away
+// - After the pre-stop delay the server stops accepting new connections
+// - In-flight requests have up to the drain timeout to finish, after that any left are closed
+// A second signal while draining will kill the process as normal
+func runServer(server *http.Server, listen func() error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- listen()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	stop()
+	beginShutdown()
+
+	preStopDelay := time.Duration(cfg.preStopDelay) * time.Second
+	drainTimeout := time.Duration(cfg.drainTimeout) * time.Second
+
+	slog.Info("🛑 Shutting down", "preStopDelay", preStopDelay, "drainTimeout", drainTimeout)
+
+	// Keep serving while readiness fails, giving load balancers time to notice before connections are refused
+	if preStopDelay > 0 {
+		time.Sleep(preStopDelay)
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(drainCtx); err != nil {
+		slog.Warn("😟 Drain timeout reached, closing remaining connections", "error", err)
+		_ = server.Close()
+	}
+
+	// Once shut down, listen always returns ErrServerClosed
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -7,14 +7,23 @@ package main
 import (
 	"embed"
 	"io/fs"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/benc-uk/http-toolkit/pkg/compress"
 )
 
 var indexFile = "index.html"
 
+// Extensions of precompressed sibling files, by the encoding they use, in order of preference
+var precompressedExts = []struct{ encoding, ext string }{
+	{compress.Brotli, ".br"},
+	{compress.Gzip, ".gz"},
+}
+
 // Use embed to include the Swagger UI files in the binary, sneaky!
 //
 //go:embed swagger-ui/*
@@ -45,7 +54,10 @@ func spaServe(w http.ResponseWriter, r *http.Request) {
 	_, err = os.Stat(path)
 	if os.IsNotExist(err) {
 		// File does not exist, serve our index file
-		http.ServeFile(w, r, filepath.Join(cfg.spaPath, indexFile))
+		if !servePrecompressed(w, r, filepath.Join(cfg.spaPath, indexFile)) {
+			http.ServeFile(w, r, filepath.Join(cfg.spaPath, indexFile))
+		}
+
 		return
 	} else if err != nil {
 		// If we got a different error, something went super wrong
@@ -53,6 +65,10 @@ func spaServe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if servePrecompressed(w, r, path) {
+		return
+	}
+
 	// Otherwise, use http.FileServer to serve the file
 	http.FileServer(http.Dir(cfg.spaPath)).ServeHTTP(w, r)
 }
@@ -66,6 +82,11 @@ func staticServe(w http.ResponseWriter, r *http.Request) {
 	routePrefixNoSlash := strings.TrimSuffix(cfg.routePrefix, "/")
 	r.URL.Path = strings.ReplaceAll(r.URL.Path, routePrefixNoSlash, "")
 
+	// Cleaning the path as if it's at the root stops any directory traversal
+	if servePrecompressed(w, r, filepath.Join(cfg.staticPath, filepath.Clean("/"+r.URL.Path))) {
+		return
+	}
+
 	http.FileServer(http.Dir(cfg.staticPath)).ServeHTTP(w, r)
 }
 
@@ -89,3 +110,69 @@ func docsServe(w http.ResponseWriter, r *http.Request) {
 	// Serve from embedded files in the subdirectory
 	http.FileServer(http.FS(swaggerSubDir)).ServeHTTP(w, r)
 }
+
+// servePrecompressed serves a precompressed sibling of a file, e.g. app.js.br for app.js, if there is one the client
+// accepts. Directories use the siblings of their index file. Returns false when there isn't one, so the file can be
+// served as normal
+func servePrecompressed(w http.ResponseWriter, r *http.Request, path string) bool {
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		// Without the trailing slash the file server redirects, so relative links in the index file work
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			return false
+		}
+
+		path = filepath.Join(path, indexFile)
+		info, err = os.Stat(path)
+	}
+
+	// Only regular files have siblings, anything else would be a file outside the directory being served
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	available := map[string]string{}
+	encodings := []string{}
+
+	for _, sibling := range precompressedExts {
+		if info, err := os.Stat(path + sibling.ext); err == nil && !info.IsDir() {
+			available[sibling.encoding] = path + sibling.ext
+			encodings = append(encodings, sibling.encoding)
+		}
+	}
+
+	if len(encodings) == 0 {
+		return false
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	encoding := compress.Negotiate(r.Header.Get("Accept-Encoding"), encodings...)
+	if encoding == "" {
+		return false
+	}
+
+	file, err := os.Open(available[encoding])
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	info, err = file.Stat()
+	if err != nil {
+		return false
+	}
+
+	// The content type comes from the original file, not the compressed one
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", encoding)
+
+	http.ServeContent(w, r, path, info.ModTime(), file)
+
+	return true
+}
@@ -0,0 +1,319 @@
+package main
+
+// ==== http-toolkit: streams.go ======================================================================================
+// Handlers which stream their responses, for testing proxy buffering, timeouts & streaming clients
+// ====================================================================================================================
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Limits on the size of streams, so a single request can't tie up the server forever
+const (
+	maxStreamItems = 1000
+	maxStreamBytes = 10 * 1024 * 1024
+)
+
+// StreamItem is sent for each line of /stream and each event of /sse
+type StreamItem struct {
+	ID        int       `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"requestId,omitempty"`
+}
+
+// streamLines sends n JSON objects, one per line, flushing each as it's written
+func streamLines(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if err != nil || n < 0 || n > maxStreamItems {
+		badRequest(w, fmt.Sprintf("Invalid n value, must be 0-%d", maxStreamItems))
+		return
+	}
+
+	interval, ok := queryDuration(w, r, "interval", 0)
+	if !ok || !streamAllowed(w, streamTime(n, interval)) {
+		return
+	}
+
+	rc := startStream(w, r, "application/x-ndjson", streamTime(n, interval))
+	enc := json.NewEncoder(w)
+
+	for i := range n {
+		if i > 0 && !pause(r, interval) {
+			return
+		}
+
+		_ = enc.Encode(StreamItem{ID: i, Timestamp: time.Now(), RequestID: middleware.GetReqID(r.Context())})
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// drip sends bytes spread evenly over a duration, after an initial delay, like httpbin's /drip
+//
+//nolint:cyclop
+func drip(w http.ResponseWriter, r *http.Request) {
+	numBytes, ok := queryInt(w, r, "numbytes", 10, maxStreamBytes)
+	if !ok {
+		return
+	}
+
+	code, ok := queryInt(w, r, "code", http.StatusOK, 599)
+	if !ok {
+		return
+	}
+
+	if code < 200 {
+		badRequest(w, "Invalid code value, must be 200-599")
+		return
+	}
+
+	duration, ok := queryDuration(w, r, "duration", 2*time.Second)
+	if !ok {
+		return
+	}
+
+	initialDelay, ok := queryDuration(w, r, "delay", 0)
+	if !ok || !streamAllowed(w, addDurations(initialDelay, duration)) {
+		return
+	}
+
+	rc := startStream(w, r, "application/octet-stream", addDurations(initialDelay, duration))
+
+	// Nothing is sent, not even headers, until after the initial delay
+	if !pause(r, initialDelay) {
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(numBytes))
+	w.WriteHeader(code)
+
+	for i := range numBytes {
+		if i > 0 && !pause(r, duration/time.Duration(numBytes)) {
+			return
+		}
+
+		_, _ = w.Write([]byte("*"))
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// chunked sends a response using chunked transfer encoding, with each chunk flushed separately
+// The size can be a comma separated list of sizes, which are cycled through for each chunk
+func chunked(w http.ResponseWriter, r *http.Request) {
+	chunks, ok := queryInt(w, r, "chunks", 10, maxStreamItems)
+	if !ok {
+		return
+	}
+
+	sizes, err := parseChunkSizes(r.URL.Query().Get("size"))
+	if err != nil {
+		badRequest(w, "Invalid size value")
+		return
+	}
+
+	total := 0
+	for i := range chunks {
+		total += sizes[i%len(sizes)]
+	}
+
+	if total > maxStreamBytes {
+		badRequest(w, fmt.Sprintf("Chunks add up to more than %d bytes", maxStreamBytes))
+		return
+	}
+
+	interval, ok := queryDuration(w, r, "interval", 100*time.Millisecond)
+	if !ok || !streamAllowed(w, streamTime(chunks, interval)) {
+		return
+	}
+
+	rc := startStream(w, r, "text/plain", streamTime(chunks, interval))
+
+	for i := range chunks {
+		if i > 0 && !pause(r, interval) {
+			return
+		}
+
+		// Each chunk is a different letter, so they are easy to tell apart
+		_, _ = w.Write([]byte(strings.Repeat(string(rune('a'+i%26)), sizes[i%len(sizes)])))
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Parse a comma separated list of chunk sizes, defaulting to 64 bytes
+func parseChunkSizes(value string) ([]int, error) {
+	sizes := []int{}
+
+	for _, s := range strings.Split(value, ",") {
+		if s == "" {
+			continue
+		}
+
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 || size > maxStreamBytes {
+			return nil, fmt.Errorf("invalid chunk size %s", s)
+		}
+
+		sizes = append(sizes, size)
+	}
+
+	if len(sizes) == 0 {
+		sizes = []int{64}
+	}
+
+	return sizes, nil
+}
+
+// serverSentEvents sends n server-sent events, if the client reconnects with Last-Event-ID it carries on from there
+func serverSentEvents(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if err != nil || n < 0 || n > maxStreamItems {
+		badRequest(w, fmt.Sprintf("Invalid n value, must be 0-%d", maxStreamItems))
+		return
+	}
+
+	interval, ok := queryDuration(w, r, "interval", time.Second)
+	if !ok || !streamAllowed(w, streamTime(n, interval)) {
+		return
+	}
+
+	event := r.URL.Query().Get("event")
+	if event == "" {
+		event = "message"
+	}
+
+	first := 0
+	if lastID, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		// Clamped, so a negative or huge ID can't start the stream at a negative or overflowed index
+		first = max(min(lastID, n)+1, 0)
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	rc := startStream(w, r, "text/event-stream", streamTime(n, interval))
+
+	// Flush the headers straight away, so clients know the stream has started
+	w.WriteHeader(http.StatusOK)
+
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	for i := first; i < n; i++ {
+		if i > first && !pause(r, interval) {
+			return
+		}
+
+		data, _ := json.Marshal(StreamItem{ID: i, Timestamp: time.Now(), RequestID: middleware.GetReqID(r.Context())})
+		_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", i, event, data)
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Set the content type & extend the write deadline to cover the stream, which could be longer than the server timeout
+func startStream(w http.ResponseWriter, r *http.Request, ct string, duration time.Duration) *http.ResponseController {
+	w.Header().Set("Content-Type", ct)
+
+	return httputil.ExtendWriteDeadline(w, r, duration)
+}
+
+// Check a stream won't take longer than the max delay, writing an error response if it would
+func streamAllowed(w http.ResponseWriter, duration time.Duration) bool {
+	if maxDelay := time.Duration(cfg.maxDelay) * time.Second; maxDelay > 0 && duration > maxDelay {
+		badRequest(w, fmt.Sprintf("Stream would take longer than the maximum delay of %s", maxDelay))
+		return false
+	}
+
+	return true
+}
+
+// Total time for count intervals, huge values stop at the longest Duration rather than overflowing & wrapping round
+func streamTime(count int, interval time.Duration) time.Duration {
+	if interval > 0 && time.Duration(count) > math.MaxInt64/interval {
+		return math.MaxInt64
+	}
+
+	return time.Duration(count) * interval
+}
+
+// Add two durations which aren't negative, stopping at the longest Duration rather than overflowing
+func addDurations(a, b time.Duration) time.Duration {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+
+	return a + b
+}
+
+// Wait between parts of a stream, returns false if the client went away while waiting
+func pause(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return r.Context().Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// Get an integer query parameter between 0 and limit, writing an error response if it's invalid
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def int, limit int) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, true
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > limit {
+		badRequest(w, fmt.Sprintf("Invalid %s value, must be 0-%d", name, limit))
+		return 0, false
+	}
+
+	return n, true
+}
+
+// Get a duration query parameter, see parseDuration, writing an error response if it's invalid
+func queryDuration(w http.ResponseWriter, r *http.Request, name string, def time.Duration) (time.Duration, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, true
+	}
+
+	d, err := parseDuration(value)
+	if err != nil {
+		badRequest(w, fmt.Sprintf("Invalid %s value: %s", name, err))
+		return 0, false
+	}
+
+	return d, true
+}
+
+func badRequest(w http.ResponseWriter, msg string) {
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write([]byte(msg))
+}
@@ -0,0 +1,104 @@
+package main
+
+// ==== http-toolkit: tracing.go ======================================================================================
+// OpenTelemetry tracing setup, exporting spans for every request to an OTLP collector or stdout
+// ====================================================================================================================
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Set up the global tracer provider & propagators for the given exporter, one of: none, otlp, stdout
+// The OTLP exporter is configured with the standard OTEL_EXPORTER_OTLP_* environment variables
+// Returns a function which flushes any pending spans, this should be called before exiting
+func setupTracing(exporter string) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+
+	var err error
+
+	switch strings.ToLower(exporter) {
+	case "none", "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		spanExporter, err = otlptracehttp.New(context.Background())
+	case "stdout":
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter: %s", exporter)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME & OTEL_RESOURCE_ATTRIBUTES are applied last, so they can override the defaults
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName("http-toolkit"), semconv.ServiceVersion(version)),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	// Accept & pass on both W3C trace context and B3, as gateways commonly use either
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+		b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader|b3.B3SingleHeader)),
+	))
+
+	return provider.Shutdown, nil
+}
+
+// Wrap the whole router, so every request gets a span with the incoming trace context as its parent
+func tracingHandler(handler http.Handler) http.Handler {
+	return otelhttp.NewHandler(handler, "HTTP", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method
+	}))
+}
+
+// Middleware which names the span after the chi route pattern once it's known, e.g. "GET /status/{code}"
+// The pattern is only complete after routing, so the span is updated once the handler has finished
+func tracingRouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		span := trace.SpanFromContext(r.Context())
+		if !span.IsRecording() {
+			return
+		}
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(attribute.String("http.route", route))
+	})
+}
@@ -0,0 +1,310 @@
+package chaos
+
+// ==== chaos: chaos.go ===============================================================================================
+// Chaos settings and the middleware which injects errors, latency, connection resets & truncated responses
+// ====================================================================================================================
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+)
+
+// Settings controls how much chaos is injected, rates are percentages of requests from 0 to 100
+type Settings struct {
+	ErrorRate float64 `json:"errorRate"`
+	// Status codes for injected errors, one is picked at random for each error
+	ErrorCodes   []int    `json:"errorCodes"`
+	Latency      *Latency `json:"latency,omitempty"`
+	ResetRate    float64  `json:"resetRate"`
+	TruncateRate float64  `json:"truncateRate"`
+}
+
+// Names of the settings, as used by Set, query parameters in the admin API and in config
+const (
+	ErrorRate    = "errorRate"
+	ErrorCodes   = "errorCodes"
+	LatencySpec  = "latency"
+	ResetRate    = "resetRate"
+	TruncateRate = "truncateRate"
+)
+
+// Default status code for injected errors
+const defaultErrorCode = http.StatusInternalServerError
+
+// Enabled is true when any chaos will be injected
+func (s Settings) Enabled() bool {
+	return s.ErrorRate > 0 || s.Latency != nil || s.ResetRate > 0 || s.TruncateRate > 0
+}
+
+// Set a single setting by name, parsing the value from a string
+func (s *Settings) Set(name string, value string) error {
+	var err error
+
+	switch name {
+	case ErrorRate:
+		s.ErrorRate, err = parseRate(value)
+	case ErrorCodes:
+		s.ErrorCodes, err = parseCodes(value)
+	case LatencySpec:
+		s.Latency, err = ParseLatency(value)
+		if errors.Is(err, ErrNoLatency) {
+			err = nil
+		}
+	case ResetRate:
+		s.ResetRate, err = parseRate(value)
+	case TruncateRate:
+		s.TruncateRate, err = parseRate(value)
+	default:
+		return fmt.Errorf("unknown chaos setting %s", name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s value: %w", name, err)
+	}
+
+	return nil
+}
+
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || rate < 0 || rate > 100 {
+		return 0, fmt.Errorf("must be a percentage between 0 and 100")
+	}
+
+	return rate, nil
+}
+
+func parseCodes(value string) ([]int, error) {
+	codes := []int{}
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		code, err := strconv.Atoi(item)
+		if err != nil || code < 400 || code > 599 {
+			return nil, fmt.Errorf("status codes must be between 400 and 599")
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// Chaos holds the current settings and injects chaos into requests, settings can be changed at any time
+type Chaos struct {
+	mu         sync.RWMutex
+	settings   Settings
+	exclude    []string
+	maxLatency time.Duration
+}
+
+// New creates a Chaos with nothing enabled, requests with paths starting with any exclude prefix are left alone
+func New(exclude ...string) *Chaos {
+	return &Chaos{exclude: exclude}
+}
+
+// SetMaxLatency caps the latency added to any request, zero is no limit
+func (c *Chaos) SetMaxLatency(limit time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxLatency = limit
+}
+
+// MaxLatency returns the cap on added latency, see SetMaxLatency
+func (c *Chaos) MaxLatency() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.maxLatency
+}
+
+// Settings returns a copy of the current settings
+func (c *Chaos) Settings() Settings {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	settings := c.settings
+	settings.ErrorCodes = append([]int{}, c.settings.ErrorCodes...)
+
+	return settings
+}
+
+// Update the named settings, if any value is invalid nothing is changed
+func (c *Chaos) Update(values map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	updated := c.settings
+	for name, value := range values {
+		if err := updated.Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	c.settings = updated
+
+	return nil
+}
+
+// Reset turns off all chaos
+func (c *Chaos) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.settings = Settings{}
+}
+
+// Middleware which injects chaos, in this order:
+// - Latency is added before anything else
+// - The connection is reset with no response
+// - An error status code is returned instead of calling the handler
+// - The handler runs but the response is cut short, and the connection closed
+func (c *Chaos) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings, maxLatency := c.Settings(), c.MaxLatency()
+		if !settings.Enabled() || c.excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if settings.Latency != nil {
+			delay := settings.Latency.Sample()
+			if maxLatency > 0 && delay > maxLatency {
+				delay = maxLatency
+			}
+
+			w.Header().Set("X-Chaos-Latency", delay.String())
+
+			httputil.ExtendWriteDeadline(w, r, delay)
+
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if roll(settings.ResetRate) {
+			// Never sent, but lets middleware further out see what happened
+			w.Header().Set("X-Chaos", "reset")
+			resetConnection(w)
+			return
+		}
+
+		if roll(settings.ErrorRate) {
+			code := defaultErrorCode
+			if len(settings.ErrorCodes) > 0 {
+				//nolint:gosec
+				code = settings.ErrorCodes[rand.Intn(len(settings.ErrorCodes))]
+			}
+
+			w.Header().Set("X-Chaos", "error")
+			w.WriteHeader(code)
+			_, _ = w.Write([]byte(http.StatusText(code)))
+
+			return
+		}
+
+		if roll(settings.TruncateRate) {
+			truncate(w, r, next)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *Chaos) excluded(path string) bool {
+	for _, prefix := range c.exclude {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// True for the given percentage of calls
+func roll(rate float64) bool {
+	//nolint:gosec
+	return rate > 0 && rand.Float64()*100 < rate
+}
+
+// Close the connection without sending a response, with a TCP RST where possible rather than a clean close
+func resetConnection(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// HTTP/2 connections can't be hijacked, aborting the handler resets the stream instead
+		panic(http.ErrAbortHandler)
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetLinger(0)
+	}
+
+	_ = conn.Close()
+}
+
+// Run the handler, then send the headers for the full response but only part of the body before closing the
+// connection, so clients see a body shorter than Content-Length. The response has to be buffered to do this
+func truncate(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	buffer := &bufferedWriter{header: w.Header(), status: http.StatusOK}
+	next.ServeHTTP(buffer, r)
+
+	body := buffer.body.Bytes()
+
+	w.Header().Set("X-Chaos", "truncate")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(buffer.status)
+
+	//nolint:gosec
+	_, _ = w.Write(body[:rand.Intn(len(body)/2+1)])
+	_ = http.NewResponseController(w).Flush()
+
+	// Aborting stops the server finishing the response, it closes the connection & doesn't log anything
+	panic(http.ErrAbortHandler)
+}
+
+// Minimal ResponseWriter which holds the response in memory, sharing the headers with the real writer
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (b *bufferedWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	if !b.wrote {
+		b.status = status
+		b.wrote = true
+	}
+}
+
+func (b *bufferedWriter) Write(data []byte) (int, error) {
+	b.wrote = true
+
+	return b.body.Write(data)
+}
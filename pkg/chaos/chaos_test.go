@@ -0,0 +1,125 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package chaos
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte(strings.Repeat("hello world ", 100)))
+})
+
+func TestUpdateIsAllOrNothing(t *testing.T) {
+	c := New()
+
+	err := c.Update(map[string]string{ErrorRate: "10", ErrorCodes: "502, 503", LatencySpec: "fixed:1s"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	settings := c.Settings()
+	if settings.ErrorRate != 10 || len(settings.ErrorCodes) != 2 || settings.Latency.String() != "fixed:1s" {
+		t.Errorf("unexpected settings: %+v", settings)
+	}
+
+	if err := c.Update(map[string]string{ResetRate: "50", TruncateRate: "101"}); err == nil {
+		t.Errorf("expected error for rate over 100")
+	}
+
+	if c.Settings().ResetRate != 0 {
+		t.Errorf("expected no settings to change when one is invalid")
+	}
+
+	for _, values := range []map[string]string{{ErrorCodes: "200"}, {ErrorRate: "lots"}, {"colour": "red"}} {
+		if err := c.Update(values); err == nil {
+			t.Errorf("expected %v to be invalid", values)
+		}
+	}
+
+	c.Reset()
+
+	if c.Settings().Enabled() {
+		t.Errorf("expected reset to disable chaos")
+	}
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	c := New("/admin")
+	_ = c.Update(map[string]string{ErrorRate: "100", ErrorCodes: "418", LatencySpec: "fixed:1ms"})
+
+	handler := c.Middleware(okHandler)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/anything", nil))
+
+	if rr.Code != http.StatusTeapot || rr.Header().Get("X-Chaos") != "error" {
+		t.Errorf("expected injected 418 error, got %d", rr.Code)
+	}
+
+	if rr.Header().Get("X-Chaos-Latency") != "1ms" {
+		t.Errorf("expected latency header, got %s", rr.Header().Get("X-Chaos-Latency"))
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/chaos", nil))
+
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Chaos-Latency") != "" {
+		t.Errorf("expected excluded path to be left alone, got %d", rr.Code)
+	}
+}
+
+func TestMiddlewareMaxLatency(t *testing.T) {
+	c := New()
+	c.SetMaxLatency(time.Millisecond)
+	_ = c.Update(map[string]string{LatencySpec: "fixed:1h"})
+
+	rr := httptest.NewRecorder()
+	c.Middleware(okHandler).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/anything", nil))
+
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Chaos-Latency") != "1ms" {
+		t.Errorf("expected latency to be capped at 1ms, got %s", rr.Header().Get("X-Chaos-Latency"))
+	}
+}
+
+func TestMiddlewareTruncates(t *testing.T) {
+	c := New()
+	_ = c.Update(map[string]string{TruncateRate: "100"})
+
+	server := httptest.NewServer(c.Middleware(okHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength != 1200 || resp.Header.Get("X-Chaos") != "truncate" {
+		t.Errorf("expected full content length & truncate header, got %d", resp.ContentLength)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err == nil || len(body) >= 1200 {
+		t.Errorf("expected a short body & read error, got %d bytes, %v", len(body), err)
+	}
+}
+
+func TestMiddlewareResets(t *testing.T) {
+	c := New()
+	_ = c.Update(map[string]string{ResetRate: "100"})
+
+	server := httptest.NewServer(c.Middleware(okHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Errorf("expected connection error, got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,119 @@
+package chaos
+
+// ==== chaos: latency.go =============================================================================================
+// Latency drawn from a distribution, described with a short spec string such as "normal:200ms,50ms"
+// ====================================================================================================================
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Supported latency distributions
+const (
+	Fixed       = "fixed"
+	Uniform     = "uniform"
+	Normal      = "normal"
+	Exponential = "exponential"
+)
+
+// ErrNoLatency is returned by ParseLatency when the spec turns latency off
+var ErrNoLatency = errors.New("no latency")
+
+// Latency is a distribution that delays are sampled from, it is written as a spec string, one of:
+// - fixed:{duration} e.g. fixed:200ms
+// - uniform:{min},{max} e.g. uniform:100ms,1s
+// - normal:{mean},{stddev} e.g. normal:200ms,50ms
+// - exponential:{mean} e.g. exponential:200ms
+type Latency struct {
+	Distribution string
+	// Params are the durations from the spec, in order
+	Params []time.Duration
+}
+
+// Number of durations each distribution needs
+var latencyParams = map[string]int{
+	Fixed:       1,
+	Uniform:     2,
+	Normal:      2,
+	Exponential: 1,
+}
+
+// ParseLatency parses a latency spec, an empty spec or "none" returns ErrNoLatency
+func ParseLatency(spec string) (*Latency, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "none" {
+		return nil, ErrNoLatency
+	}
+
+	distribution, rawParams, _ := strings.Cut(spec, ":")
+
+	count, ok := latencyParams[distribution]
+	if !ok {
+		return nil, fmt.Errorf("unknown distribution %s, must be fixed, uniform, normal or exponential", distribution)
+	}
+
+	latency := &Latency{Distribution: distribution}
+
+	for _, raw := range strings.Split(rawParams, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("%s is not a valid duration", raw)
+		}
+
+		latency.Params = append(latency.Params, d)
+	}
+
+	if len(latency.Params) != count {
+		return nil, fmt.Errorf("%s latency needs %d durations", distribution, count)
+	}
+
+	if distribution == Uniform && latency.Params[1] < latency.Params[0] {
+		return nil, fmt.Errorf("uniform latency max must not be less than min")
+	}
+
+	return latency, nil
+}
+
+// Sample a delay from the distribution, this is never negative
+func (l *Latency) Sample() time.Duration {
+	var d float64
+
+	//nolint:gosec
+	switch l.Distribution {
+	case Fixed:
+		d = float64(l.Params[0])
+	case Uniform:
+		d = float64(l.Params[0]) + rand.Float64()*float64(l.Params[1]-l.Params[0])
+	case Normal:
+		d = float64(l.Params[0]) + rand.NormFloat64()*float64(l.Params[1])
+	case Exponential:
+		d = rand.ExpFloat64() * float64(l.Params[0])
+	}
+
+	// Long tails & huge params can go past what a Duration can hold
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	return time.Duration(max(d, 0))
+}
+
+// String returns the spec the latency was parsed from
+func (l *Latency) String() string {
+	params := make([]string, len(l.Params))
+	for i, p := range l.Params {
+		params[i] = p.String()
+	}
+
+	return l.Distribution + ":" + strings.Join(params, ",")
+}
+
+// MarshalText writes the latency as a spec string, so it is readable when returned as JSON
+func (l *Latency) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
@@ -0,0 +1,76 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package chaos
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseLatency(t *testing.T) {
+	valid := []string{"fixed:200ms", "uniform:100ms,1s", "normal:200ms, 50ms", "exponential:2s"}
+	for _, spec := range valid {
+		latency, err := ParseLatency(spec)
+		if err != nil || latency == nil {
+			t.Errorf("expected %s to parse, got %v", spec, err)
+		}
+	}
+
+	if latency, _ := ParseLatency("normal:200ms, 50ms"); latency.String() != "normal:200ms,50ms" {
+		t.Errorf("unexpected string form: %s", latency.String())
+	}
+
+	for _, spec := range []string{"", "none"} {
+		if latency, err := ParseLatency(spec); latency != nil || !errors.Is(err, ErrNoLatency) {
+			t.Errorf("expected %q to mean no latency, got %v %v", spec, latency, err)
+		}
+	}
+
+	invalid := []string{"gamma:1s", "fixed", "fixed:soon", "fixed:1s,2s", "uniform:1s", "uniform:2s,1s", "fixed:-1s"}
+	for _, spec := range invalid {
+		if _, err := ParseLatency(spec); err == nil {
+			t.Errorf("expected %s to be invalid", spec)
+		}
+	}
+}
+
+func TestLatencySampleHuge(t *testing.T) {
+	huge, _ := ParseLatency("uniform:2000000h,2562047h")
+
+	for range 100 {
+		if d := huge.Sample(); d < 2000000*time.Hour {
+			t.Fatalf("huge sample should not overflow: %v", d)
+		}
+	}
+}
+
+func TestLatencySample(t *testing.T) {
+	fixed, _ := ParseLatency("fixed:150ms")
+	if d := fixed.Sample(); d != 150*time.Millisecond {
+		t.Errorf("expected fixed latency of 150ms, got %v", d)
+	}
+
+	uniform, _ := ParseLatency("uniform:100ms,200ms")
+	normal, _ := ParseLatency("normal:10ms,50ms")
+	exponential, _ := ParseLatency("exponential:100ms")
+
+	var total time.Duration
+
+	for range 1000 {
+		if d := uniform.Sample(); d < 100*time.Millisecond || d > 200*time.Millisecond {
+			t.Fatalf("uniform sample out of range: %v", d)
+		}
+
+		if d := normal.Sample(); d < 0 {
+			t.Fatalf("normal sample should never be negative: %v", d)
+		}
+
+		total += exponential.Sample()
+	}
+
+	// The mean of 1000 samples should be close to 100ms, this is very loose to avoid flaky tests
+	if mean := total / 1000; mean < 70*time.Millisecond || mean > 130*time.Millisecond {
+		t.Errorf("expected exponential mean near 100ms, got %v", mean)
+	}
+}
@@ -0,0 +1,365 @@
+package compress
+
+// ==== compress: compress.go =========================================================================================
+// Middleware which compresses responses with gzip, brotli or zstd, negotiated from the Accept-Encoding header
+// ====================================================================================================================
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported encodings, as used in the Accept-Encoding & Content-Encoding headers
+const (
+	Brotli = "br"
+	Zstd   = "zstd"
+	Gzip   = "gzip"
+)
+
+// Encodings the middleware supports, in order of preference when a client accepts several equally
+var Encodings = []string{Brotli, Zstd, Gzip}
+
+// DefaultTypes are the content types compressed when none are given, types which are already compressed such as
+// images & archives gain nothing from compressing them again
+var DefaultTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"text/xml",
+	"text/csv",
+	"text/markdown",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/x-ndjson",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// Options for the compression middleware
+type Options struct {
+	// Responses smaller than this many bytes are sent uncompressed
+	MinSize int
+	// Content types to compress, a type ending in /* matches all subtypes e.g. text/*
+	Types []string
+}
+
+// Compressor holds the options, and pools of encoders so they can be reused between responses
+type Compressor struct {
+	minSize int
+	types   []string
+	pools   map[string]*sync.Pool
+}
+
+// An encoder for one of the supported encodings, they all have these methods
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// New creates a Compressor, if no types are given DefaultTypes is used
+func New(opts Options) *Compressor {
+	types := opts.Types
+	if len(types) == 0 {
+		types = DefaultTypes
+	}
+
+	return &Compressor{
+		minSize: opts.MinSize,
+		types:   types,
+		pools: map[string]*sync.Pool{
+			Brotli: {New: func() any { return brotli.NewWriterLevel(nil, 4) }},
+			Gzip:   {New: func() any { return gzip.NewWriter(nil) }},
+			Zstd: {New: func() any {
+				enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+				return enc
+			}},
+		},
+	}
+}
+
+// Negotiate picks the encoding to use from an Accept-Encoding header, out of the supported encodings
+// The encoding with the highest q value wins, ties go to the earliest supported encoding
+// An empty string is returned if none of them are acceptable
+func Negotiate(acceptEncoding string, supported ...string) string {
+	accepted := map[string]float64{}
+
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if name == "" {
+			continue
+		}
+
+		if name == "x-gzip" {
+			name = Gzip
+		}
+
+		q := 1.0
+
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+
+			q = parsed
+		}
+
+		accepted[name] = q
+	}
+
+	best, bestQ := "", 0.0
+
+	for _, encoding := range supported {
+		q, ok := accepted[encoding]
+		if !ok {
+			// A wildcard covers any encoding not listed explicitly
+			q = accepted["*"]
+		}
+
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// Middleware which compresses responses, responses are left alone when:
+// - The client doesn't accept any of the supported encodings
+// - They are smaller than the minimum size, or not one of the content types to compress
+// - They already have a Content-Encoding, are partial content, or have no body
+// Flushes are passed through, so streamed responses are still sent as they are written
+func (c *Compressor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Upgraded connections such as WebSockets need the original writer to hijack the connection
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		encoding := ""
+		if r.Method != http.MethodHead {
+			encoding = Negotiate(r.Header.Get("Accept-Encoding"), Encodings...)
+		}
+
+		cw := &writer{ResponseWriter: w, compressor: c, encoding: encoding}
+		next.ServeHTTP(cw, r)
+		cw.close()
+	})
+}
+
+// Check if a content type is one to be compressed
+func (c *Compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range c.types {
+		if prefix, wildcard := strings.CutSuffix(t, "*"); wildcard {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// How a response is being sent, it's pending until it's known if it will be compressed
+type mode int
+
+const (
+	pending mode = iota
+	passthrough
+	compressing
+)
+
+// ResponseWriter which holds back the start of the response until it's known if it should be compressed
+type writer struct {
+	http.ResponseWriter
+	compressor  *Compressor
+	encoding    string
+	status      int
+	wroteHeader bool
+	mode        mode
+	buffer      []byte
+	encoder     encoder
+}
+
+func (cw *writer) WriteHeader(status int) {
+	// Informational responses such as 103 Early Hints are sent straight away, the real response follows
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if cw.wroteHeader {
+		return
+	}
+
+	cw.status = status
+	cw.wroteHeader = true
+
+	if !cw.eligible() {
+		cw.start(false)
+		return
+	}
+
+	// When the size is known up front there's no need to wait for the body
+	if length, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil {
+		cw.start(length >= cw.compressor.minSize && cw.compressible())
+	}
+}
+
+func (cw *writer) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	switch cw.mode {
+	case compressing:
+		return cw.encoder.Write(data)
+	case passthrough:
+		return cw.ResponseWriter.Write(data)
+	}
+
+	cw.buffer = append(cw.buffer, data...)
+
+	if len(cw.buffer) >= cw.compressor.minSize {
+		cw.start(cw.compressible())
+	}
+
+	return len(data), nil
+}
+
+// Flush sends anything held back, deciding whether to compress if that isn't known yet
+func (cw *writer) Flush() {
+	_ = cw.FlushError()
+}
+
+// FlushError is used by http.ResponseController, so errors flushing can be returned
+func (cw *writer) FlushError() error {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.mode == pending {
+		cw.start(cw.compressible())
+	}
+
+	if cw.mode == compressing {
+		if err := cw.encoder.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap allows http.ResponseController to reach the original writer, e.g. to set deadlines
+func (cw *writer) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Check if the response could be compressed, ignoring its size & content type
+func (cw *writer) eligible() bool {
+	return cw.encoding != "" && cw.Header().Get("Content-Encoding") == "" &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusPartialContent &&
+		cw.status != http.StatusNotModified && cw.status != http.StatusSwitchingProtocols
+}
+
+// Check the content type is one to compress, sniffing it from the body if the handler didn't set it
+func (cw *writer) compressible() bool {
+	contentType := cw.Header().Get("Content-Type")
+	if contentType == "" && len(cw.buffer) > 0 {
+		contentType = http.DetectContentType(cw.buffer)
+		cw.Header().Set("Content-Type", contentType)
+	}
+
+	return cw.compressor.compressible(contentType)
+}
+
+// Send the headers & anything buffered, either compressed or as it is
+func (cw *writer) start(compress bool) {
+	if compress {
+		cw.mode = compressing
+
+		cw.encoder = cw.compressor.pools[cw.encoding].Get().(encoder)
+		cw.encoder.Reset(cw.ResponseWriter)
+
+		header := cw.Header()
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+
+		// Ranges would refer to the uncompressed body, and the compressed body is a different representation
+		header.Del("Accept-Ranges")
+
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+	} else {
+		cw.mode = passthrough
+	}
+
+	// Caches need to know the response depends on Accept-Encoding, even when this one wasn't compressed
+	if compress || cw.compressor.compressible(cw.Header().Get("Content-Type")) {
+		addVary(cw.Header())
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buffer) > 0 {
+		if compress {
+			_, _ = cw.encoder.Write(cw.buffer)
+		} else {
+			_, _ = cw.ResponseWriter.Write(cw.buffer)
+		}
+
+		cw.buffer = nil
+	}
+}
+
+// Finish the response once the handler has returned
+func (cw *writer) close() {
+	switch cw.mode {
+	case pending:
+		// Only reached when the handler wrote something, but less than the minimum size
+		if cw.wroteHeader {
+			cw.start(false)
+		}
+	case compressing:
+		_ = cw.encoder.Close()
+		cw.encoder.Reset(nil)
+		cw.compressor.pools[cw.encoding].Put(cw.encoder)
+	case passthrough:
+	}
+}
+
+// Add Accept-Encoding to the Vary header, unless it's already there
+func addVary(header http.Header) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", "Accept-Encoding")
+}
@@ -0,0 +1,205 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", ""},
+		{"gzip", Gzip},
+		{"gzip, deflate, br, zstd", Brotli},
+		{"gzip;q=1.0, br;q=0.5", Gzip},
+		{"zstd, gzip", Zstd},
+		{"x-gzip", Gzip},
+		{"deflate", ""},
+		{"*", Brotli},
+		{"*, br;q=0", Zstd},
+		{"gzip;q=0", ""},
+		{"identity", ""},
+	}
+
+	for _, test := range tests {
+		if got := Negotiate(test.accept, Encodings...); got != test.expected {
+			t.Errorf("Negotiate(%q) = %q, expected %q", test.accept, got, test.expected)
+		}
+	}
+}
+
+func serve(handler http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	c := New(Options{MinSize: 100})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	rr := httptest.NewRecorder()
+	c.Middleware(handler).ServeHTTP(rr, req)
+
+	return rr
+}
+
+func decode(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+
+	var reader io.Reader
+
+	switch encoding {
+	case Gzip:
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader = gz
+	case Brotli:
+		reader = brotli.NewReader(body)
+	case Zstd:
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+
+		reader = zr
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestMiddlewareCompresses(t *testing.T) {
+	text := strings.Repeat("hello compression ", 100)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("ETag", `"abc"`)
+		_, _ = w.Write([]byte(text[:50]))
+		_, _ = w.Write([]byte(text[50:]))
+	}
+
+	for _, encoding := range Encodings {
+		rr := serve(handler, encoding)
+
+		if rr.Header().Get("Content-Encoding") != encoding || rr.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("expected %s encoding with Vary header, got %v", encoding, rr.Header())
+		}
+
+		if rr.Header().Get("ETag") != `W/"abc"` {
+			t.Errorf("expected ETag to be made weak, got %s", rr.Header().Get("ETag"))
+		}
+
+		if rr.Body.Len() >= len(text) {
+			t.Errorf("expected %s body to be smaller than %d, got %d", encoding, len(text), rr.Body.Len())
+		}
+
+		if body := decode(t, encoding, rr.Body); body != text {
+			t.Errorf("expected %s body to decode to the original text", encoding)
+		}
+	}
+}
+
+func TestMiddlewareSkips(t *testing.T) {
+	large := strings.Repeat("x", 500)
+
+	tests := map[string]struct {
+		handler http.HandlerFunc
+		accept  string
+	}{
+		"small body": {func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("small"))
+		}, "gzip"},
+		"not accepted": {func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(large))
+		}, ""},
+		"image type": {func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte(large))
+		}, "gzip"},
+		"already encoded": {func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "deflate")
+			_, _ = w.Write([]byte(large))
+		}, "gzip"},
+		"partial content": {func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte(large))
+		}, "gzip"},
+		"small content length": {func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", "5")
+			_, _ = w.Write([]byte("small"))
+		}, "gzip"},
+	}
+
+	for name, test := range tests {
+		rr := serve(test.handler, test.accept)
+
+		if rr.Header().Get("Content-Encoding") == Gzip {
+			t.Errorf("%s: expected response not to be compressed", name)
+		}
+
+		if body := rr.Body.String(); body != large && body != "small" {
+			t.Errorf("%s: expected body to be unchanged, got %d bytes", name, len(body))
+		}
+	}
+}
+
+func TestMiddlewareFlush(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = w.Write([]byte("{\"id\":1}\n"))
+
+		// Smaller than the minimum size, but flushing means it has to be sent now
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("unexpected error flushing: %v", err)
+		}
+
+		// Check what has been sent so far decodes, before the handler finishes
+		rr, _ := w.(*writer).ResponseWriter.(*httptest.ResponseRecorder)
+
+		gz, err := gzip.NewReader(strings.NewReader(rr.Body.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line := make([]byte, 9)
+		if _, err := io.ReadFull(gz, line); err != nil || string(line) != "{\"id\":1}\n" {
+			t.Errorf("expected first line to be flushed, got %q %v", line, err)
+		}
+	}
+
+	rr := serve(handler, "gzip")
+	if !rr.Flushed || rr.Header().Get("Content-Encoding") != Gzip {
+		t.Errorf("expected flushed gzip response, got %v", rr.Header())
+	}
+}
+
+func TestMiddlewareSniffsContentType(t *testing.T) {
+	rr := serve(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html><body>" + strings.Repeat("hello ", 50) + "</body></html>"))
+	}, "br")
+
+	if rr.Header().Get("Content-Encoding") != Brotli || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expected sniffed HTML to be compressed, got %v", rr.Header())
+	}
+}
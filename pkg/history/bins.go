@@ -0,0 +1,104 @@
+package history
+
+// ==== history: bins.go ==============================================================================================
+// Named bins, each with their own history, so parallel users of one server can keep their requests apart
+// ====================================================================================================================
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrTooManyBins is returned when trying to create a bin and the limit has been reached
+var ErrTooManyBins = errors.New("maximum number of bins reached")
+
+// Bin is a named collection of captured requests
+type Bin struct {
+	ID       string
+	Name     string
+	Created  time.Time
+	Requests *Store
+}
+
+// Bins holds all bins, keyed by their ID
+type Bins struct {
+	mu      sync.RWMutex
+	bins    map[string]*Bin
+	maxBins int
+	binSize int
+}
+
+// NewBins creates an empty set of bins, up to maxBins can be created each holding binSize requests
+func NewBins(maxBins int, binSize int) *Bins {
+	return &Bins{
+		bins:    make(map[string]*Bin),
+		maxBins: maxBins,
+		binSize: binSize,
+	}
+}
+
+// Create a new bin with a unique ID, the name is optional and only used as a label
+func (b *Bins) Create(name string) (*Bin, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.bins) >= b.maxBins {
+		return nil, ErrTooManyBins
+	}
+
+	bin := &Bin{
+		ID:       uuid.NewString(),
+		Name:     name,
+		Created:  time.Now(),
+		Requests: NewStore(b.binSize),
+	}
+
+	b.bins[bin.ID] = bin
+
+	return bin, nil
+}
+
+// Get a bin by ID, returns false if it doesn't exist
+func (b *Bins) Get(id string) (*Bin, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	bin, found := b.bins[id]
+
+	return bin, found
+}
+
+// Delete a bin and all of its requests, returns false if it doesn't exist
+func (b *Bins) Delete(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, found := b.bins[id]; !found {
+		return false
+	}
+
+	delete(b.bins, id)
+
+	return true
+}
+
+// List all bins, oldest first
+func (b *Bins) List() []*Bin {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	list := make([]*Bin, 0, len(b.bins))
+	for _, bin := range b.bins {
+		list = append(list, bin)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+
+	return list
+}
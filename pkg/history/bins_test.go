@@ -0,0 +1,72 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package history
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+)
+
+func TestBinsCreateAndGet(t *testing.T) {
+	bins := NewBins(5, 10)
+
+	bin, err := bins.Create("team-a")
+	if err != nil {
+		t.Fatalf("unexpected error creating bin: %v", err)
+	}
+
+	if bin.ID == "" || bin.Name != "team-a" {
+		t.Errorf("unexpected bin created: %+v", bin)
+	}
+
+	found, ok := bins.Get(bin.ID)
+	if !ok || found != bin {
+		t.Fatalf("expected to find bin %s", bin.ID)
+	}
+
+	found.Requests.Add(httputil.RequestDetails{ID: "1"})
+
+	if bin.Requests.Len() != 1 {
+		t.Errorf("expected bin to have 1 request, got %d", bin.Requests.Len())
+	}
+}
+
+func TestBinsAreIsolated(t *testing.T) {
+	bins := NewBins(5, 10)
+	binA, _ := bins.Create("a")
+	binB, _ := bins.Create("b")
+
+	binA.Requests.Add(httputil.RequestDetails{ID: "1"})
+
+	if binB.Requests.Len() != 0 {
+		t.Errorf("expected bin b to be empty, got %d requests", binB.Requests.Len())
+	}
+}
+
+func TestBinsLimitAndDelete(t *testing.T) {
+	bins := NewBins(2, 10)
+	first, _ := bins.Create("")
+	_, _ = bins.Create("")
+
+	if _, err := bins.Create(""); !errors.Is(err, ErrTooManyBins) {
+		t.Errorf("expected ErrTooManyBins, got %v", err)
+	}
+
+	if !bins.Delete(first.ID) {
+		t.Errorf("expected delete to succeed")
+	}
+
+	if bins.Delete(first.ID) {
+		t.Errorf("expected second delete to fail")
+	}
+
+	if len(bins.List()) != 1 {
+		t.Errorf("expected 1 bin after delete, got %d", len(bins.List()))
+	}
+
+	if _, err := bins.Create(""); err != nil {
+		t.Errorf("expected create to succeed after delete, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package history
+
+// ==== history: file.go ==============================================================================================
+// Persist captured requests to disk as JSONL, with size based rotation, and load them back again on startup
+// ====================================================================================================================
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+)
+
+// Maximum length of a single line when loading, requests with big bodies can make for long lines
+const maxLineSize = 64 * 1024 * 1024
+
+// FileSink appends requests to a JSONL file, one request per line
+// When the file grows beyond maxSize it's rotated, keeping up to maxBackups old files named file.1, file.2 etc
+type FileSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewFileSink opens or creates the file at path ready for appending
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	sink := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+
+	return sink, nil
+}
+
+// Write a request to the file as a single line of JSON, rotating the file first if needed
+func (f *FileSink) Write(rd httputil.RequestDetails) error {
+	line, err := json.Marshal(rd)
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+
+	return err
+}
+
+// Close the underlying file
+func (f *FileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+func (f *FileSink) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+// Shuffle the backups along by one, dropping the oldest, then start a new empty file
+func (f *FileSink) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if f.maxBackups < 1 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		return f.open()
+	}
+
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(backupName(f.path, i), backupName(f.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := os.Rename(f.path, backupName(f.path, 1)); err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+// LoadFile reads requests back from a file written by a FileSink, including any backups, oldest first
+// Missing files are not an error, lines which can't be parsed are skipped
+func LoadFile(path string, maxBackups int) ([]httputil.RequestDetails, error) {
+	requests := []httputil.RequestDetails{}
+
+	files := []string{}
+	for i := maxBackups; i >= 1; i-- {
+		files = append(files, backupName(path, i))
+	}
+
+	files = append(files, path)
+
+	for _, name := range files {
+		loaded, err := loadLines(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return requests, fmt.Errorf("loading %s: %w", name, err)
+		}
+
+		requests = append(requests, loaded...)
+	}
+
+	return requests, nil
+}
+
+func loadLines(name string) ([]httputil.RequestDetails, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	requests := []httputil.RequestDetails{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	for scanner.Scan() {
+		var rd httputil.RequestDetails
+		if err := json.Unmarshal(scanner.Bytes(), &rd); err != nil {
+			continue
+		}
+
+		requests = append(requests, rd)
+	}
+
+	return requests, scanner.Err()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
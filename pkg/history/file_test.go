@@ -0,0 +1,90 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+)
+
+func TestFileSinkWriteAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+
+	sink, err := NewFileSink(path, 0, 3)
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+
+	store := NewStore(10)
+	store.SetSink(sink)
+	store.Add(httputil.RequestDetails{ID: "a", Method: "GET", Headers: map[string]string{"X-Test": "1"}})
+	store.Add(httputil.RequestDetails{ID: "b", Method: "POST", Body: "hello"})
+	_ = sink.Close()
+
+	loaded, err := LoadFile(path, 3)
+	if err != nil {
+		t.Fatalf("unexpected error loading: %v", err)
+	}
+
+	if len(loaded) != 2 {
+		t.Fatalf("expected 2 requests loaded, got %d", len(loaded))
+	}
+
+	if loaded[0].ID != "a" || loaded[0].Headers["X-Test"] != "1" || loaded[1].Body != "hello" {
+		t.Errorf("loaded requests don't match what was written: %+v", loaded)
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+
+	// Small max size so every write rotates
+	sink, err := NewFileSink(path, 10, 2)
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := sink.Write(httputil.RequestDetails{ID: strconv.Itoa(i)}); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+	}
+
+	_ = sink.Close()
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no more than 2 backups to be kept")
+	}
+
+	loaded, err := LoadFile(path, 2)
+	if err != nil {
+		t.Fatalf("unexpected error loading: %v", err)
+	}
+
+	// Only the current file and two backups survive, loaded oldest first
+	want := []string{"2", "3", "4"}
+	if len(loaded) != len(want) {
+		t.Fatalf("expected %d requests, got %d", len(want), len(loaded))
+	}
+
+	for i, id := range want {
+		if loaded[i].ID != id {
+			t.Errorf("expected request %d to be %s, got %s", i, id, loaded[i].ID)
+		}
+	}
+}
+
+func TestLoadFileMissing(t *testing.T) {
+	loaded, err := LoadFile(filepath.Join(t.TempDir(), "nope.jsonl"), 3)
+	if err != nil {
+		t.Errorf("expected no error for missing file, got %v", err)
+	}
+
+	if len(loaded) != 0 {
+		t.Errorf("expected no requests, got %d", len(loaded))
+	}
+}
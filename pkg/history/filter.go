@@ -0,0 +1,68 @@
+package history
+
+// ==== history: filter.go ============================================================================================
+// Simple filtering of captured requests by method, path and header
+// ====================================================================================================================
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+)
+
+// Filter is used to select requests from a Store, empty fields match everything
+type Filter struct {
+	// Method matches the HTTP method, case insensitive
+	Method string
+	// Path matches requests with a path starting with this prefix
+	Path string
+	// Header matches requests which have this header
+	Header string
+	// HeaderValue when set, the header must also have this exact value
+	HeaderValue string
+}
+
+// NewFilter creates a Filter from URL query parameters: method, path & header
+// The header parameter can be a name e.g. `X-GitHub-Event` or a name & value e.g. `X-GitHub-Event:push`
+func NewFilter(query url.Values) Filter {
+	filter := Filter{
+		Method: query.Get("method"),
+		Path:   query.Get("path"),
+	}
+
+	header := query.Get("header")
+	if name, value, found := strings.Cut(header, ":"); found {
+		filter.Header = strings.TrimSpace(name)
+		filter.HeaderValue = strings.TrimSpace(value)
+	} else {
+		filter.Header = strings.TrimSpace(header)
+	}
+
+	return filter
+}
+
+// Match checks if a request satisfies all the conditions in the filter
+func (f Filter) Match(rd httputil.RequestDetails) bool {
+	if f.Method != "" && !strings.EqualFold(f.Method, rd.Method) {
+		return false
+	}
+
+	if f.Path != "" && !strings.HasPrefix(rd.Path, f.Path) {
+		return false
+	}
+
+	if f.Header != "" {
+		value, exists := rd.Headers[http.CanonicalHeaderKey(f.Header)]
+		if !exists {
+			return false
+		}
+
+		if f.HeaderValue != "" && value != f.HeaderValue {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,156 @@
+package history
+
+// ==== history: store.go =============================================================================================
+// A bounded in-memory ring buffer of captured requests, so we can look back at what the inspector saw
+// ====================================================================================================================
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+)
+
+// Sink receives every request added to a Store, e.g. to persist them somewhere
+type Sink interface {
+	Write(rd httputil.RequestDetails) error
+}
+
+// How many requests can queue up for a subscriber before they start getting dropped
+const subscriberBuffer = 32
+
+// Store holds the most recent requests, once full the oldest entries are overwritten
+type Store struct {
+	mu          sync.RWMutex
+	entries     []httputil.RequestDetails
+	next        int
+	count       int
+	subscribers map[chan httputil.RequestDetails]struct{}
+	sink        Sink
+}
+
+// NewStore creates a Store which will hold up to size requests
+func NewStore(size int) *Store {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Store{
+		entries:     make([]httputil.RequestDetails, size),
+		subscribers: make(map[chan httputil.RequestDetails]struct{}),
+	}
+}
+
+// Add a request to the store, overwriting the oldest entry if the store is full
+func (s *Store) Add(rd httputil.RequestDetails) {
+	s.mu.Lock()
+
+	s.entries[s.next] = rd
+	s.next = (s.next + 1) % len(s.entries)
+
+	if s.count < len(s.entries) {
+		s.count++
+	}
+
+	// Notify subscribers, slow subscribers will miss requests rather than block capturing
+	for sub := range s.subscribers {
+		select {
+		case sub <- rd:
+		default:
+		}
+	}
+
+	sink := s.sink
+	s.mu.Unlock()
+
+	// The sink is written to outside the lock, so slow disk writes don't hold up readers & subscribers
+	if sink != nil {
+		if err := sink.Write(rd); err != nil {
+			slog.Error("💥 Failed to write request to sink", "error", err)
+		}
+	}
+}
+
+// SetSink sets a sink which will receive all requests added to the store from now on
+func (s *Store) SetSink(sink Sink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sink = sink
+}
+
+// Subscribe returns a channel which receives every request added to the store from now on
+// Call the returned function to unsubscribe, which also closes the channel
+func (s *Store) Subscribe() (<-chan httputil.RequestDetails, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := make(chan httputil.RequestDetails, subscriberBuffer)
+	s.subscribers[sub] = struct{}{}
+
+	var once sync.Once
+
+	return sub, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			delete(s.subscribers, sub)
+			close(sub)
+		})
+	}
+}
+
+// List returns the requests matching the filter, newest first
+func (s *Store) List(filter Filter) []httputil.RequestDetails {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := []httputil.RequestDetails{}
+
+	for i := 1; i <= s.count; i++ {
+		rd := s.entries[(s.next-i+len(s.entries))%len(s.entries)]
+		if filter.Match(rd) {
+			results = append(results, rd)
+		}
+	}
+
+	return results
+}
+
+// Get a single request by ID, returns false if it's not in the store
+func (s *Store) Get(id string) (httputil.RequestDetails, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := 0; i < s.count; i++ {
+		if s.entries[i].ID == id {
+			return s.entries[i], true
+		}
+	}
+
+	return httputil.RequestDetails{}, false
+}
+
+// Clear removes all requests from the store
+func (s *Store) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = make([]httputil.RequestDetails, len(s.entries))
+	s.next = 0
+	s.count = 0
+}
+
+// Len is the number of requests currently held
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.count
+}
+
+// Size is the maximum number of requests the store will hold
+func (s *Store) Size() int {
+	return len(s.entries)
+}
@@ -0,0 +1,151 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package history
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+)
+
+func TestStoreAddAndList(t *testing.T) {
+	store := NewStore(3)
+
+	for i := 0; i < 5; i++ {
+		store.Add(httputil.RequestDetails{ID: strconv.Itoa(i), Method: "GET", Path: "/test"})
+	}
+
+	if store.Len() != 3 {
+		t.Fatalf("expected 3 entries, got %d", store.Len())
+	}
+
+	list := store.List(Filter{})
+	want := []string{"4", "3", "2"}
+
+	for i, id := range want {
+		if list[i].ID != id {
+			t.Errorf("expected entry %d to have ID %s, got %s", i, id, list[i].ID)
+		}
+	}
+
+	if _, found := store.Get("0"); found {
+		t.Errorf("expected oldest entry to have been overwritten")
+	}
+
+	if rd, found := store.Get("3"); !found || rd.ID != "3" {
+		t.Errorf("expected to find entry with ID 3")
+	}
+}
+
+func TestStoreClear(t *testing.T) {
+	store := NewStore(10)
+	store.Add(httputil.RequestDetails{ID: "a"})
+	store.Add(httputil.RequestDetails{ID: "b"})
+
+	store.Clear()
+
+	if store.Len() != 0 {
+		t.Errorf("expected empty store after clear, got %d entries", store.Len())
+	}
+
+	if len(store.List(Filter{})) != 0 {
+		t.Errorf("expected no entries listed after clear")
+	}
+
+	if _, found := store.Get("a"); found {
+		t.Errorf("expected entry to be removed after clear")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	rd := httputil.RequestDetails{
+		Method:  "POST",
+		Path:    "/webhooks/github",
+		Headers: map[string]string{"X-Github-Event": "push"},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{"Empty filter", "", true},
+		{"Method match", "method=post", true},
+		{"Method mismatch", "method=GET", false},
+		{"Path prefix match", "path=/webhooks", true},
+		{"Path mismatch", "path=/other", false},
+		{"Header present", "header=x-github-event", true},
+		{"Header missing", "header=X-Other", false},
+		{"Header value match", "header=X-GitHub-Event:push", true},
+		{"Header value mismatch", "header=X-GitHub-Event:ping", false},
+		{"Combined", "method=POST&path=/webhooks&header=X-GitHub-Event:push", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			if got := NewFilter(query).Match(rd); got != tt.want {
+				t.Errorf("expected match %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStoreSubscribe(t *testing.T) {
+	store := NewStore(10)
+
+	sub, unsubscribe := store.Subscribe()
+	store.Add(httputil.RequestDetails{ID: "a"})
+
+	select {
+	case rd := <-sub:
+		if rd.ID != "a" {
+			t.Errorf("expected subscriber to receive request a, got %s", rd.ID)
+		}
+	default:
+		t.Fatalf("expected subscriber to receive a request")
+	}
+
+	unsubscribe()
+	unsubscribe()
+	store.Add(httputil.RequestDetails{ID: "b"})
+
+	if _, open := <-sub; open {
+		t.Errorf("expected channel to be closed after unsubscribe")
+	}
+}
+
+// Sink which blocks until released, like a slow disk
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (b *blockingSink) Write(rd httputil.RequestDetails) error {
+	<-b.release
+	return nil
+}
+
+func TestStoreSlowSinkDoesNotBlockReaders(t *testing.T) {
+	store := NewStore(10)
+	sink := &blockingSink{release: make(chan struct{})}
+	store.SetSink(sink)
+
+	defer close(sink.release)
+
+	go store.Add(httputil.RequestDetails{ID: "a"})
+
+	// The request is in the store straight away, while the sink is still writing it
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if len(store.List(Filter{})) == 1 {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("expected the store to be readable while the sink is blocked")
+}
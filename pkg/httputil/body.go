@@ -0,0 +1,270 @@
+package httputil
+
+// ==== httputils: body.go ============================================================================================
+// Read, decode & parse request bodies, compressed JSON & XML bodies are turned into structured output
+// ====================================================================================================================
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Limit on the size of a decompressed body, so a small compressed request can't exhaust memory
+const maxDecodedBody = 50 * 1024 * 1024
+
+// Limit on how much of a body over the inspect limit is spooled to disk, anything past this is passed on unread
+var maxSpoolSize int64 = 1024 * 1024 * 1024
+
+// Size returned by readBody when the body was too large to measure
+const unknownSize = -1
+
+var errDecodedTooLarge = errors.New("decoded body is too large")
+
+// ErrNotParsable is returned by ParseBody when the body is empty or the content type isn't JSON or XML
+var ErrNotParsable = errors.New("body is not JSON or XML")
+
+// XMLNode is a generic representation of an XML element, used when parsing XML bodies
+type XMLNode struct {
+	Name     string            `json:"name"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Children []XMLNode         `json:"children,omitempty"`
+}
+
+// DecodeBody reverses the Content-Encoding of a body, e.g. "gzip" or "deflate, br"
+// Encodings are listed in the order they were applied, so are removed in reverse
+func DecodeBody(contentEncoding string, body []byte) ([]byte, error) {
+	encodings := strings.Split(contentEncoding, ",")
+
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+
+		decoded, err := decode(encoding, body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode %s body: %w", encoding, err)
+		}
+
+		body = decoded
+	}
+
+	return body, nil
+}
+
+func decode(encoding string, body []byte) ([]byte, error) {
+	var reader io.Reader
+
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		reader = gz
+
+	case "deflate":
+		// Deflate should be zlib wrapped, but plenty of clients send raw deflate so handle both
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			zr = flate.NewReader(bytes.NewReader(body))
+		}
+		defer zr.Close()
+
+		reader = zr
+
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		reader = zr
+
+	default:
+		return nil, errors.New("unsupported encoding")
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedBody+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded) > maxDecodedBody {
+		return nil, errDecodedTooLarge
+	}
+
+	return decoded, nil
+}
+
+// ParseBody parses JSON & XML bodies based on the content type, returning ErrNotParsable for anything else
+// JSON is returned as generic maps & slices, XML as a tree of XMLNode
+func ParseBody(contentType string, body []byte) (any, error) {
+	if len(body) == 0 {
+		return nil, ErrNotParsable
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrNotParsable
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var parsed any
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			return nil, err
+		}
+
+		return parsed, nil
+
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return parseXML(body)
+	}
+
+	return nil, ErrNotParsable
+}
+
+func parseXML(body []byte) (*XMLNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+
+	// Stack of open elements, the root is kept once it's closed
+	stack := []*XMLNode{}
+
+	var root *XMLNode
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &XMLNode{Name: xmlName(t.Name)}
+			for _, attr := range t.Attr {
+				if node.Attrs == nil {
+					node.Attrs = map[string]string{}
+				}
+
+				node.Attrs[xmlName(attr.Name)] = attr.Value
+			}
+
+			stack = append(stack, node)
+
+		case xml.EndElement:
+			node := stack[len(stack)-1]
+			node.Text = strings.TrimSpace(node.Text)
+			stack = stack[:len(stack)-1]
+
+			if len(stack) == 0 {
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, *node)
+			}
+
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Text += string(t)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("no XML element found")
+	}
+
+	return root, nil
+}
+
+// Element & attribute names with the namespace prefix, if there is one
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
+
+// Read the body of a request up to limit bytes, returning what was read along with the size & hash of the whole body
+// The request body is replaced so it can be read again in full, anything over the limit is spooled to a temp file
+// rather than held in memory. The temp file is removed once the request is finished. A limit of zero means no limit
+// If the body is bigger than the spool can hold, or it can't be spooled at all, the size is unknownSize with no hash
+func readBody(r *http.Request, limit int64) (body []byte, size int64, hash string, err error) {
+	hasher := sha256.New()
+	reader := io.TeeReader(r.Body, hasher)
+
+	if limit <= 0 {
+		body, err = io.ReadAll(reader)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		return body, int64(len(body)), hex.EncodeToString(hasher.Sum(nil)), err
+	}
+
+	// Read one byte past the limit, so we know if there's more without needing to spool small bodies
+	body, err = io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil || int64(len(body)) <= limit {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		return body, int64(len(body)), hex.EncodeToString(hasher.Sum(nil)), err
+	}
+
+	original := r.Body
+
+	spool, err := os.CreateTemp("", "http-toolkit-body-*")
+	if err != nil {
+		// Still pass the whole body on, we just can't know its size or hash
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), original))
+
+		return body[:limit], unknownSize, "", err
+	}
+
+	// Clean up when the request is done, rather than relying on whoever reads the body to close it
+	context.AfterFunc(r.Context(), func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	})
+
+	rest, err := io.Copy(spool, io.LimitReader(reader, maxSpoolSize+1))
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+
+	// Anything past the spool limit is still in the original body, so it follows on from the spool
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), spool, original))
+
+	if rest > maxSpoolSize {
+		return body[:limit], unknownSize, "", err
+	}
+
+	return body[:limit], int64(len(body)) + rest, hex.EncodeToString(hasher.Sum(nil)), err
+}
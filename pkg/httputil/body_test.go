@@ -0,0 +1,258 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package httputil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+
+	var w io.WriteCloser
+
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "deflate":
+		w = zlib.NewWriter(buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(buf)
+	case "zstd":
+		w, _ = zstd.NewWriter(buf)
+	}
+
+	_, _ = w.Write(data)
+	_ = w.Close()
+
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	data := []byte(`{"hello":"world"}`)
+
+	tests := []struct {
+		header   string
+		compress string
+	}{
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate", "raw-deflate"},
+		{"br", "br"},
+		{"zstd", "zstd"},
+		{"identity", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.compress, func(t *testing.T) {
+			body := data
+			if tt.compress != "" {
+				body = compress(t, tt.compress, data)
+			}
+
+			decoded, err := DecodeBody(tt.header, body)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !bytes.Equal(decoded, data) {
+				t.Errorf("expected %s, got %s", data, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeBodyStacked(t *testing.T) {
+	data := []byte("stacked encodings")
+	body := compress(t, "br", compress(t, "gzip", data))
+
+	decoded, err := DecodeBody("gzip, br", body)
+	if err != nil || !bytes.Equal(decoded, data) {
+		t.Errorf("expected %s, got %s %v", data, decoded, err)
+	}
+
+	if _, err := DecodeBody("compress", data); err == nil {
+		t.Errorf("expected error for unsupported encoding")
+	}
+
+	if _, err := DecodeBody("gzip", data); err == nil {
+		t.Errorf("expected error for body which isn't gzipped")
+	}
+}
+
+func TestParseBody(t *testing.T) {
+	parsed, err := ParseBody("application/vnd.api+json", []byte(`{"a":[1,2],"b":{"c":true}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	obj, ok := parsed.(map[string]any)
+	if !ok || obj["b"].(map[string]any)["c"] != true {
+		t.Errorf("unexpected parsed JSON: %v", parsed)
+	}
+
+	if _, err := ParseBody("application/json", []byte(`{"a":`)); err == nil {
+		t.Errorf("expected error for invalid JSON")
+	}
+
+	parsed, err = ParseBody("text/xml; charset=utf-8", []byte(`<order id="7"><item qty="2">Tea</item><item>Cake</item></order>`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	root, ok := parsed.(*XMLNode)
+	if !ok || root.Name != "order" || root.Attrs["id"] != "7" || len(root.Children) != 2 {
+		t.Fatalf("unexpected parsed XML: %+v", parsed)
+	}
+
+	if root.Children[0].Text != "Tea" || root.Children[0].Attrs["qty"] != "2" || root.Children[1].Text != "Cake" {
+		t.Errorf("unexpected XML children: %+v", root.Children)
+	}
+
+	if parsed, err := ParseBody("text/plain", []byte("hello")); parsed != nil || !errors.Is(err, ErrNotParsable) {
+		t.Errorf("expected plain text to not be parsed, got %v %v", parsed, err)
+	}
+}
+
+func TestNewRequestDetailsCompressedBody(t *testing.T) {
+	data := []byte(`{"event":"push"}`)
+	compressed := compress(t, "gzip", data)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(compressed))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	rd := NewRequestDetails(req, true)
+
+	if rd.Body != string(data) || !rd.BodyDecoded || rd.BodyBase64 || rd.BodyError != "" {
+		t.Errorf("unexpected body fields: %+v", rd)
+	}
+
+	if rd.BodyParsed.(map[string]any)["event"] != "push" {
+		t.Errorf("unexpected parsed body: %v", rd.BodyParsed)
+	}
+
+	// Downstream handlers must still get the original compressed body
+	body, _ := io.ReadAll(req.Body)
+	if !bytes.Equal(body, compressed) {
+		t.Errorf("expected request body to be reset to the original bytes")
+	}
+}
+
+func TestNewRequestDetailsBinaryBody(t *testing.T) {
+	data := []byte{0x0a, 0x03, 0xff, 0xfe, 0x00}
+
+	req := httptest.NewRequest(http.MethodPost, "/grpc", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+
+	rd := NewRequestDetails(req, true)
+
+	if !rd.BodyBase64 || rd.Body != "CgP//gA=" {
+		t.Errorf("expected base64 body, got %s %v", rd.Body, rd.BodyBase64)
+	}
+
+	if !bytes.Equal(rd.BodyBytes(), data) {
+		t.Errorf("expected BodyBytes to return the original data, got %v", rd.BodyBytes())
+	}
+}
+
+func TestNewRequestDetailsTruncatedUTF8(t *testing.T) {
+	// Limit of 4 cuts the second é in half
+	req := httptest.NewRequest(http.MethodPost, "/text", strings.NewReader("héé wörld"))
+	req.Header.Set("Content-Type", "text/plain")
+
+	rd := NewRequestDetailsWithOptions(req, Options{ReadBody: true, MaxBodySize: 4})
+
+	if rd.BodyBase64 || rd.Body != "hé" || !rd.BodyTruncated {
+		t.Errorf("expected truncated text body, got %q base64 %v", rd.Body, rd.BodyBase64)
+	}
+}
+
+func TestNewRequestDetailsOverSpoolLimit(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	defer func(size int64) { maxSpoolSize = size }(maxSpoolSize)
+	maxSpoolSize = 20
+
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(data))
+
+	rd := NewRequestDetailsWithOptions(req, Options{ReadBody: true, MaxBodySize: 30})
+
+	// The size & hash can't be known, but it's still truncated
+	if !rd.BodyTruncated || rd.BodySize != 0 || rd.BodySHA256 != "" || len(rd.Body) != 30 {
+		t.Errorf("unexpected body fields: len %d, truncated %v, size %d, hash %q",
+			len(rd.Body), rd.BodyTruncated, rd.BodySize, rd.BodySHA256)
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	if !bytes.Equal(body, data) {
+		t.Errorf("expected full body to be passed on, got %d bytes", len(body))
+	}
+}
+
+func TestNewRequestDetailsMaxBodySize(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	sum := sha256.Sum256(data)
+	fullHash := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name          string
+		max           int64
+		wantBody      int
+		wantTruncated bool
+	}{
+		{"No limit", 0, 100, false},
+		{"Under limit", 200, 100, false},
+		{"Exactly at limit", 100, 100, false},
+		{"Over limit", 30, 30, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Bodies over the limit are spooled to a temp file, keep those out of the real temp dir
+			t.Setenv("TMPDIR", t.TempDir())
+
+			req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(data))
+			req.Header.Set("Content-Type", "application/json")
+
+			rd := NewRequestDetailsWithOptions(req, Options{ReadBody: true, MaxBodySize: tt.max})
+
+			if len(rd.Body) != tt.wantBody || rd.BodyTruncated != tt.wantTruncated || rd.BodySize != 100 {
+				t.Errorf("unexpected body fields: len %d, truncated %v, size %d", len(rd.Body), rd.BodyTruncated, rd.BodySize)
+			}
+
+			if rd.BodySHA256 != fullHash {
+				t.Errorf("expected hash of the full body %s, got %s", fullHash, rd.BodySHA256)
+			}
+
+			if tt.wantTruncated && rd.BodyError == "" {
+				t.Errorf("expected truncated JSON body to not be parsed")
+			}
+
+			// Downstream handlers always get the complete body
+			body, _ := io.ReadAll(req.Body)
+			if !bytes.Equal(body, data) {
+				t.Errorf("expected full body to be passed on, got %d bytes", len(body))
+			}
+		})
+	}
+}
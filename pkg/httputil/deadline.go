@@ -0,0 +1,43 @@
+package httputil
+
+// ==== httputils: deadline.go ========================================================================================
+// Extend the write deadline of responses which wait before they are written, e.g. delays & streams
+// ====================================================================================================================
+
+import (
+	"net/http"
+	"time"
+)
+
+// Time allowed to write the response once the wait is over
+var writeSlack = 10 * time.Second
+
+// ExtendWriteDeadline gives a response time to wait for the given duration and then be written, so long waits don't
+// hit the server write timeout. The deadline is only ever moved later, never earlier than the server's own timeout
+// would be from now, and isn't set at all when the server has no write timeout
+func ExtendWriteDeadline(w http.ResponseWriter, r *http.Request, wait time.Duration) *http.ResponseController {
+	rc := http.NewResponseController(w)
+
+	now := time.Now()
+	deadline := now.Add(wait).Add(writeSlack)
+
+	if srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server); ok {
+		if srv.WriteTimeout <= 0 {
+			return rc
+		}
+
+		deadline = later(deadline, now.Add(srv.WriteTimeout))
+	}
+
+	_ = rc.SetWriteDeadline(deadline)
+
+	return rc
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,51 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package httputil
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExtendWriteDeadline(t *testing.T) {
+	defer func(slack time.Duration) { writeSlack = slack }(writeSlack)
+	writeSlack = 10 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		wait    time.Duration
+	}{
+		// Waiting past the server timeout works, as the deadline is extended
+		{"Extended", 100 * time.Millisecond, 300 * time.Millisecond},
+		// A short wait must not bring the deadline in from the server timeout
+		{"Never shortened", 500 * time.Millisecond, 0},
+		{"No server timeout", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ExtendWriteDeadline(w, r, tt.wait)
+				time.Sleep(max(tt.wait, 200*time.Millisecond))
+				_, _ = w.Write([]byte("done"))
+			}))
+			server.Config.WriteTimeout = tt.timeout
+			server.Start()
+			defer server.Close()
+
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatalf("expected response to be written, got %v", err)
+			}
+			defer resp.Body.Close()
+
+			if body, _ := io.ReadAll(resp.Body); string(body) != "done" {
+				t.Errorf("expected full response, got %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package httputil
+
+// ==== httputils: export.go ==========================================================================================
+// Render captured RequestDetails into formats other tools can use to send the request again
+// ====================================================================================================================
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// HAR is a HTTP Archive (v1.2) document, see http://www.softwareishard.com/blog/har-12-spec/
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int         `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARResponse is required by the spec, but we only ever capture requests so it's always empty
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type HARTimings struct {
+	Send    int `json:"send"`
+	Wait    int `json:"wait"`
+	Receive int `json:"receive"`
+}
+
+// ErrBodyTruncated is returned by ToRequest when only part of the body was captured, so it can't be sent again as it was
+var ErrBodyTruncated = errors.New("body was truncated when captured, so the request can't be sent as it was")
+
+// Headers which shouldn't be copied when exporting, as the client sending the request will set them itself
+var skipExportHeaders = map[string]bool{
+	"Content-Length": true,
+	"Connection":     true,
+}
+
+// URL builds the full URL of the request, using baseURL for the scheme and host e.g. http://localhost:8000
+func (rd RequestDetails) URL(baseURL string) string {
+	u := strings.TrimSuffix(baseURL, "/") + rd.Path
+
+	query := url.Values(rd.AllQuery())
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	return u
+}
+
+// ToCurl renders the request as a curl command line
+func (rd RequestDetails) ToCurl(baseURL string) string {
+	var sb strings.Builder
+
+	sb.WriteString("curl -X " + rd.Method + " " + shellQuote(rd.URL(baseURL)))
+
+	for _, header := range rd.exportHeaders() {
+		sb.WriteString(" \\\n  -H " + shellQuote(header.Name+": "+header.Value))
+	}
+
+	command := sb.String()
+
+	switch {
+	case rd.Body != "" && rd.BodyBase64:
+		// Binary bodies can't go on the command line, so pipe them in from base64
+		command = "echo " + shellQuote(rd.Body) + " | base64 -d | " + command + " \\\n  --data-binary @-"
+	case rd.Body != "":
+		command += " \\\n  --data-raw " + shellQuote(rd.Body)
+	}
+
+	if warning := rd.truncatedWarning(); warning != "" {
+		command = "# Warning: " + warning + "\n" + command
+	}
+
+	return command + "\n"
+}
+
+// ToHTTPFile renders the request as a block in a .http file, as used by REST Client & httpYac
+func (rd RequestDetails) ToHTTPFile(baseURL string) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("### %s %s\n", rd.Method, rd.Path))
+
+	if warning := rd.truncatedWarning(); warning != "" {
+		sb.WriteString("# Warning: " + warning + "\n")
+	}
+
+	sb.WriteString(rd.Method + " " + rd.URL(baseURL) + "\n")
+
+	for _, header := range rd.exportHeaders() {
+		sb.WriteString(header.Name + ": " + header.Value + "\n")
+	}
+
+	if rd.Body != "" && rd.BodyBase64 {
+		sb.WriteString(fmt.Sprintf("\n# Binary body of %d bytes not included\n", len(rd.BodyBytes())))
+	} else if rd.Body != "" {
+		sb.WriteString("\n" + rd.Body + "\n")
+	}
+
+	return sb.String()
+}
+
+// ToRequest creates a new http.Request from the captured details, ready to send it again to baseURL
+// Returns ErrBodyTruncated if only part of the body was captured
+func (rd RequestDetails) ToRequest(ctx context.Context, baseURL string) (*http.Request, error) {
+	if rd.BodyTruncated {
+		return nil, ErrBodyTruncated
+	}
+
+	req, err := http.NewRequestWithContext(ctx, rd.Method, rd.URL(baseURL), bytes.NewReader(rd.BodyBytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, header := range rd.exportHeaders() {
+		req.Header.Add(header.Name, header.Value)
+	}
+
+	return req, nil
+}
+
+// NewHAR creates a HAR document holding the given requests
+func NewHAR(creatorVersion string, baseURL string, requests ...RequestDetails) HAR {
+	entries := make([]HAREntry, 0, len(requests))
+	for _, rd := range requests {
+		entries = append(entries, rd.toHAREntry(baseURL))
+	}
+
+	return HAR{
+		Log: HARLog{
+			Version: "1.2",
+			Creator: HARCreator{Name: "http-toolkit", Version: creatorVersion},
+			Entries: entries,
+		},
+	}
+}
+
+func (rd RequestDetails) toHAREntry(baseURL string) HAREntry {
+	queryString := sortedNameValues(rd.AllQuery(), nil)
+
+	// Parse cookies by making a fake request, there's no other way to get at the parser
+	cookies := []HARNameValue{}
+	cookieReq := http.Request{Header: http.Header{"Cookie": rd.AllHeaders()["Cookie"]}}
+
+	for _, c := range cookieReq.Cookies() {
+		cookies = append(cookies, HARNameValue{Name: c.Name, Value: c.Value})
+	}
+
+	httpVersion := rd.Proto
+	if httpVersion == "" {
+		httpVersion = "HTTP/1.1"
+	}
+
+	req := HARRequest{
+		Method:      rd.Method,
+		URL:         rd.URL(baseURL),
+		HTTPVersion: httpVersion,
+		Cookies:     cookies,
+		Headers:     rd.exportHeaders(),
+		QueryString: queryString,
+		HeadersSize: -1,
+		BodySize:    len(rd.BodyBytes()),
+	}
+
+	if rd.Body != "" {
+		req.PostData = &HARPostData{
+			MimeType: rd.Headers["Content-Type"],
+			Text:     rd.Body,
+		}
+
+		comments := []string{}
+		if rd.BodyBase64 {
+			comments = append(comments, "Binary body, text is base64 encoded")
+		}
+
+		if warning := rd.truncatedWarning(); warning != "" {
+			comments = append(comments, "Warning: "+warning)
+		}
+
+		req.PostData.Comment = strings.Join(comments, ". ")
+	}
+
+	return HAREntry{
+		StartedDateTime: rd.Timestamp,
+		Request:         req,
+		Response: HARResponse{
+			Cookies:     []HARNameValue{},
+			Headers:     []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+}
+
+// Warning for exports when only part of the body was captured, empty if the body is complete
+func (rd RequestDetails) truncatedWarning() string {
+	if !rd.BodyTruncated {
+		return ""
+	}
+
+	if rd.BodySize > 0 {
+		return fmt.Sprintf("body was truncated when captured, only the first %d of %d bytes are included",
+			len(rd.BodyBytes()), rd.BodySize)
+	}
+
+	return fmt.Sprintf("body was truncated when captured, only the first %d bytes are included", len(rd.BodyBytes()))
+}
+
+// Headers to include when exporting, sorted by name so the output is stable
+func (rd RequestDetails) exportHeaders() []HARNameValue {
+	if !rd.BodyDecoded {
+		return sortedNameValues(rd.AllHeaders(), skipExportHeaders)
+	}
+
+	// The body has been decompressed, so sending the Content-Encoding header with it would be wrong
+	skip := map[string]bool{"Content-Encoding": true}
+	for name := range skipExportHeaders {
+		skip[name] = true
+	}
+
+	return sortedNameValues(rd.AllHeaders(), skip)
+}
+
+// Flatten multi-value maps into a list of name & value pairs sorted by name, optionally skipping some names
+func sortedNameValues(values map[string][]string, skip map[string]bool) []HARNameValue {
+	names := []string{}
+
+	for name := range values {
+		if !skip[name] {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	pairs := []HARNameValue{}
+	for _, name := range names {
+		for _, value := range values[name] {
+			pairs = append(pairs, HARNameValue{Name: name, Value: value})
+		}
+	}
+
+	return pairs
+}
+
+// Wrap a string in single quotes for use in a shell command, escaping any single quotes inside it
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
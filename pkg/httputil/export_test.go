@@ -0,0 +1,199 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package httputil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+var exportRequest = RequestDetails{
+	Method: "POST",
+	Path:   "/webhooks/github",
+	Headers: map[string]string{
+		"Content-Type":   "application/json",
+		"Content-Length": "19",
+		"Cookie":         "session=abc; theme=dark",
+		"X-Note":         "it's here",
+	},
+	Query:     map[string]string{"b": "2", "a": "1"},
+	Body:      `{"event":"it's on"}`,
+	Timestamp: "2024-01-02T03:04:05Z",
+}
+
+func TestURL(t *testing.T) {
+	got := exportRequest.URL("http://localhost:8000/")
+	want := "http://localhost:8000/webhooks/github?a=1&b=2"
+
+	if got != want {
+		t.Errorf("expected URL %s, got %s", want, got)
+	}
+}
+
+func TestToCurl(t *testing.T) {
+	got := exportRequest.ToCurl("http://localhost:8000")
+
+	expected := []string{
+		"curl -X POST 'http://localhost:8000/webhooks/github?a=1&b=2'",
+		`-H 'Content-Type: application/json'`,
+		`-H 'X-Note: it'\''s here'`,
+		`--data-raw '{"event":"it'\''s on"}'`,
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(got, e) {
+			t.Errorf("expected curl command to contain %q, got:\n%s", e, got)
+		}
+	}
+
+	if strings.Contains(got, "Content-Length") {
+		t.Errorf("expected Content-Length header to be skipped, got:\n%s", got)
+	}
+}
+
+func TestToHTTPFile(t *testing.T) {
+	got := exportRequest.ToHTTPFile("http://localhost:8000")
+
+	want := "### POST /webhooks/github\n" +
+		"POST http://localhost:8000/webhooks/github?a=1&b=2\n" +
+		"Content-Type: application/json\n" +
+		"Cookie: session=abc; theme=dark\n" +
+		"X-Note: it's here\n" +
+		"\n" +
+		`{"event":"it's on"}` + "\n"
+
+	if got != want {
+		t.Errorf("unexpected .http output, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestNewHAR(t *testing.T) {
+	har := NewHAR("1.0", "http://localhost:8000", exportRequest)
+
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 1 {
+		t.Fatalf("unexpected HAR log: %+v", har.Log)
+	}
+
+	req := har.Log.Entries[0].Request
+	if req.Method != "POST" || req.URL != "http://localhost:8000/webhooks/github?a=1&b=2" {
+		t.Errorf("unexpected HAR request: %+v", req)
+	}
+
+	if len(req.Cookies) != 2 || req.Cookies[0].Name != "session" || req.Cookies[1].Value != "dark" {
+		t.Errorf("unexpected HAR cookies: %+v", req.Cookies)
+	}
+
+	if len(req.QueryString) != 2 || req.QueryString[0].Name != "a" {
+		t.Errorf("unexpected HAR query string: %+v", req.QueryString)
+	}
+
+	if req.PostData == nil || req.PostData.MimeType != "application/json" {
+		t.Errorf("unexpected HAR post data: %+v", req.PostData)
+	}
+}
+
+func TestToRequest(t *testing.T) {
+	req, err := exportRequest.ToRequest(context.Background(), "http://example.net:3000/api")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if req.Method != "POST" || req.URL.String() != "http://example.net:3000/api/webhooks/github?a=1&b=2" {
+		t.Errorf("unexpected request: %s %s", req.Method, req.URL)
+	}
+
+	if req.Header.Get("X-Note") != "it's here" || req.Header.Get("Content-Length") != "" {
+		t.Errorf("unexpected headers: %v", req.Header)
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != exportRequest.Body {
+		t.Errorf("expected body %s, got %s", exportRequest.Body, body)
+	}
+}
+
+func TestExportMultiValues(t *testing.T) {
+	rd := RequestDetails{
+		Method:       "GET",
+		Path:         "/test",
+		HeaderValues: map[string][]string{"Set-Thing": {"a=1, b=2", "c=3"}},
+		QueryValues:  map[string][]string{"id": {"1", "2"}},
+	}
+
+	if got := rd.URL("http://localhost"); got != "http://localhost/test?id=1&id=2" {
+		t.Errorf("expected repeated query params, got %s", got)
+	}
+
+	curl := rd.ToCurl("http://localhost")
+	if !strings.Contains(curl, "-H 'Set-Thing: a=1, b=2'") || !strings.Contains(curl, "-H 'Set-Thing: c=3'") {
+		t.Errorf("expected each header value to be exported separately, got:\n%s", curl)
+	}
+
+	req, _ := rd.ToRequest(context.Background(), "http://localhost")
+	if len(req.Header.Values("Set-Thing")) != 2 {
+		t.Errorf("expected 2 header values on request, got %v", req.Header.Values("Set-Thing"))
+	}
+}
+
+func TestExportBinaryAndDecoded(t *testing.T) {
+	rd := RequestDetails{
+		Method:      "POST",
+		Path:        "/upload",
+		Headers:     map[string]string{"Content-Encoding": "gzip", "Content-Type": "application/octet-stream"},
+		Body:        "CgP//gA=",
+		BodyBase64:  true,
+		BodyDecoded: true,
+	}
+
+	curl := rd.ToCurl("http://localhost")
+	if !strings.HasPrefix(curl, "echo 'CgP//gA=' | base64 -d | curl") || !strings.Contains(curl, "--data-binary @-") {
+		t.Errorf("expected binary body to be piped into curl, got:\n%s", curl)
+	}
+
+	if strings.Contains(curl, "Content-Encoding") {
+		t.Errorf("expected Content-Encoding to be dropped for a decoded body, got:\n%s", curl)
+	}
+
+	req, _ := rd.ToRequest(context.Background(), "http://localhost")
+	body, _ := io.ReadAll(req.Body)
+
+	if string(body) != "\x0a\x03\xff\xfe\x00" {
+		t.Errorf("expected raw binary body on request, got %v", body)
+	}
+
+	if !strings.Contains(rd.ToHTTPFile("http://localhost"), "# Binary body of 5 bytes not included") {
+		t.Errorf("expected binary body to be left out of .http file")
+	}
+}
+
+func TestExportTruncated(t *testing.T) {
+	rd := RequestDetails{Method: "POST", Path: "/upload", Body: "0123456789", BodySize: 100, BodyTruncated: true}
+
+	warning := "body was truncated when captured, only the first 10 of 100 bytes are included"
+
+	if curl := rd.ToCurl("http://localhost"); !strings.HasPrefix(curl, "# Warning: "+warning+"\ncurl -X POST") {
+		t.Errorf("expected warning before the curl command, got:\n%s", curl)
+	}
+
+	if file := rd.ToHTTPFile("http://localhost"); !strings.Contains(file, "# Warning: "+warning+"\n") {
+		t.Errorf("expected warning in .http file, got:\n%s", file)
+	}
+
+	entry := NewHAR("1.0", "http://localhost", rd).Log.Entries[0]
+	if entry.Request.PostData == nil || !strings.Contains(entry.Request.PostData.Comment, warning) {
+		t.Errorf("expected warning in HAR post data comment, got %+v", entry.Request.PostData)
+	}
+
+	if _, err := rd.ToRequest(context.Background(), "http://localhost"); !errors.Is(err, ErrBodyTruncated) {
+		t.Errorf("expected ErrBodyTruncated, got %v", err)
+	}
+
+	// Bodies too big to measure don't have a size
+	rd.BodySize = 0
+	if curl := rd.ToCurl("http://localhost"); !strings.Contains(curl, "only the first 10 bytes are included") {
+		t.Errorf("expected warning without a size, got:\n%s", curl)
+	}
+}
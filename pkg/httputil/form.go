@@ -0,0 +1,147 @@
+package httputil
+
+// ==== httputils: form.go ============================================================================================
+// Parse urlencoded & multipart form bodies into fields & file parts, so uploads can be inspected
+// ====================================================================================================================
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+)
+
+// ErrNotForm is returned by ParseForm when the body is empty or the content type isn't a form
+var ErrNotForm = errors.New("body is not a form")
+
+// FormDetails holds the parsed contents of a form body
+type FormDetails struct {
+	Fields map[string][]string `json:"fields,omitempty"`
+	Files  []FilePart          `json:"files,omitempty"`
+}
+
+// FilePart describes a file uploaded in a multipart form, the content itself is not kept
+type FilePart struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	// Preview holds the first few bytes of the file base64 encoded, only when enabled
+	Preview string `json:"preview,omitempty"`
+}
+
+// ParseForm parses a body with the given content type as a form
+// Returns ErrNotForm if the content type isn't urlencoded or multipart form data
+// previewSize is the number of bytes of each file to include as a preview, zero disables previews
+func ParseForm(contentType string, body []byte, previewSize int) (*FormDetails, error) {
+	if len(body) == 0 {
+		return nil, ErrNotForm
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrNotForm
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+
+		return &FormDetails{Fields: values}, nil
+
+	case "multipart/form-data":
+		boundary := params["boundary"]
+		if boundary == "" {
+			return nil, errors.New("multipart form has no boundary")
+		}
+
+		return parseMultipart(multipart.NewReader(bytes.NewReader(body), boundary), previewSize)
+	}
+
+	return nil, ErrNotForm
+}
+
+// Walk the parts one at a time rather than using ReadForm, so files are hashed and never spooled to disk
+func parseMultipart(mr *multipart.Reader, previewSize int) (*FormDetails, error) {
+	form := &FormDetails{Fields: map[string][]string{}}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return form, err
+		}
+
+		// Parts without a filename are regular fields
+		if part.FileName() == "" {
+			value, err := io.ReadAll(part)
+			if err != nil {
+				return form, err
+			}
+
+			form.Fields[part.FormName()] = append(form.Fields[part.FormName()], string(value))
+
+			continue
+		}
+
+		hash := sha256.New()
+		preview := &bytes.Buffer{}
+
+		size, err := io.Copy(io.MultiWriter(hash, &limitedWriter{w: preview, n: previewSize}), part)
+		if err != nil {
+			return form, err
+		}
+
+		file := FilePart{
+			Field:       part.FormName(),
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Size:        size,
+			SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		}
+
+		if preview.Len() > 0 {
+			file.Preview = base64.StdEncoding.EncodeToString(preview.Bytes())
+		}
+
+		form.Files = append(form.Files, file)
+	}
+
+	return form, nil
+}
+
+// limitedWriter writes at most n bytes to w and silently discards the rest
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if lw.n > 0 {
+		chunk := p
+		if len(chunk) > lw.n {
+			chunk = chunk[:lw.n]
+		}
+
+		written, err := lw.w.Write(chunk)
+		lw.n -= written
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,89 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package httputil
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseFormURLEncoded(t *testing.T) {
+	form, err := ParseForm("application/x-www-form-urlencoded; charset=utf-8", []byte("name=bob&tag=a&tag=b"), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if form == nil || form.Fields["name"][0] != "bob" || len(form.Fields["tag"]) != 2 {
+		t.Errorf("unexpected form: %+v", form)
+	}
+}
+
+func TestParseFormNotForm(t *testing.T) {
+	form, err := ParseForm("application/json", []byte(`{"a":1}`), 0)
+	if form != nil || !errors.Is(err, ErrNotForm) {
+		t.Errorf("expected ErrNotForm for JSON, got %+v %v", form, err)
+	}
+
+	form, err = ParseForm("", []byte("a=1"), 0)
+	if form != nil || !errors.Is(err, ErrNotForm) {
+		t.Errorf("expected ErrNotForm with no content type, got %+v %v", form, err)
+	}
+}
+
+func TestParseFormMultipart(t *testing.T) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+
+	_ = mw.WriteField("title", "holiday")
+	fw, _ := mw.CreateFormFile("photo", "beach.bin")
+	_, _ = fw.Write([]byte{0x00, 0xFF, 0x10, 0x20, 0x30})
+	_ = mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	rd := NewRequestDetailsWithOptions(req, Options{ReadBody: true, FilePreviewSize: 3})
+
+	if rd.Form == nil || rd.FormError != "" {
+		t.Fatalf("expected form to be parsed, got %+v %s", rd.Form, rd.FormError)
+	}
+
+	if rd.Form.Fields["title"][0] != "holiday" {
+		t.Errorf("unexpected fields: %+v", rd.Form.Fields)
+	}
+
+	if len(rd.Form.Files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(rd.Form.Files))
+	}
+
+	file := rd.Form.Files[0]
+	if file.Field != "photo" || file.Filename != "beach.bin" || file.ContentType != "application/octet-stream" {
+		t.Errorf("unexpected file part: %+v", file)
+	}
+
+	// sha256 of 00 ff 10 20 30
+	if file.Size != 5 || file.SHA256 != "907c473d062bc4740087b4e5dc360dbf407a959dfcc35a696c90521c0419bab4" {
+		t.Errorf("unexpected file size or hash: %+v", file)
+	}
+
+	if file.Preview != "AP8Q" {
+		t.Errorf("expected preview of first 3 bytes, got %s", file.Preview)
+	}
+
+	// The body must still be readable by anything downstream
+	if !strings.Contains(string(rd.BodyBytes()), "holiday") {
+		t.Errorf("expected raw body to be kept")
+	}
+}
+
+func TestParseFormMultipartNoBoundary(t *testing.T) {
+	_, err := ParseForm("multipart/form-data", []byte("junk"), 0)
+	if err == nil {
+		t.Errorf("expected error for multipart without boundary")
+	}
+}
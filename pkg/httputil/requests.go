@@ -5,27 +5,75 @@ package httputil
 // ====================================================================================================================
 
 import (
-	"bytes"
-	"io"
-	"log"
+	"encoding/base64"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Output format versions for RequestDetails, see Format
+const (
+	// FormatV1 is the original flat format, where multiple header & query values are joined with commas
+	FormatV1 = 1
+	// FormatV2 adds headerValues & queryValues, which hold every value separately so nothing is lost
+	FormatV2 = 2
 )
 
 // RequestDetails is a struct to hold details about an http.Request
 type RequestDetails struct {
-	Method     string            `json:"method,omitempty"`
-	Path       string            `json:"path,omitempty"`
-	RemoteAddr string            `json:"remoteAddr,omitempty"`
-	Headers    map[string]string `json:"headers,omitempty"`
-	Query      map[string]string `json:"query,omitempty"`
-	Body       string            `json:"body,omitempty"`
-	Timestamp  string            `json:"timestamp,omitempty"`
+	ID               string              `json:"id,omitempty"`
+	Method           string              `json:"method,omitempty"`
+	Path             string              `json:"path,omitempty"`
+	Proto            string              `json:"proto,omitempty"`
+	Scheme           string              `json:"scheme,omitempty"`
+	Host             string              `json:"host,omitempty"`
+	FullURL          string              `json:"url,omitempty"`
+	RawQuery         string              `json:"rawQuery,omitempty"`
+	RemoteAddr       string              `json:"remoteAddr,omitempty"`
+	Headers          map[string]string   `json:"headers,omitempty"`
+	HeaderValues     map[string][]string `json:"headerValues,omitempty"`
+	Query            map[string]string   `json:"query,omitempty"`
+	QueryValues      map[string][]string `json:"queryValues,omitempty"`
+	ContentLength    int64               `json:"contentLength,omitempty"`
+	TransferEncoding []string            `json:"transferEncoding,omitempty"`
+	Trailers         map[string]string   `json:"trailers,omitempty"`
+	Body             string              `json:"body,omitempty"`
+	BodySize         int64               `json:"bodySize,omitempty"`
+	BodySHA256       string              `json:"bodySha256,omitempty"`
+	BodyTruncated    bool                `json:"bodyTruncated,omitempty"`
+	BodyBase64       bool                `json:"bodyBase64,omitempty"`
+	BodyDecoded      bool                `json:"bodyDecoded,omitempty"`
+	BodyParsed       any                 `json:"bodyParsed,omitempty"`
+	BodyError        string              `json:"bodyError,omitempty"`
+	Form             *FormDetails        `json:"form,omitempty"`
+	FormError        string              `json:"formError,omitempty"`
+	TLS              *TLSDetails         `json:"tls,omitempty"`
+	Trace            *TraceContext       `json:"trace,omitempty"`
+	Timestamp        string              `json:"timestamp,omitempty"`
+}
+
+// Options controls how much of a request NewRequestDetailsWithOptions inspects
+type Options struct {
+	// ReadBody reads the body into the details, decoding it and parsing it if it's JSON, XML or a form
+	ReadBody bool
+	// FilePreviewSize is how many bytes of each uploaded file to include as a preview, zero disables previews
+	FilePreviewSize int
+	// MaxBodySize is the most bytes of the body to inspect, the rest is still passed on but not shown, zero is no limit
+	MaxBodySize int64
 }
 
 // Create a RequestDetails struct from an http.Request
 func NewRequestDetails(r *http.Request, readBody bool) RequestDetails {
+	return NewRequestDetailsWithOptions(r, Options{ReadBody: readBody})
+}
+
+// Create a RequestDetails struct from an http.Request, with options controlling how the body is inspected
+func NewRequestDetailsWithOptions(r *http.Request, opts Options) RequestDetails {
 	headers := make(map[string]string)
 	for k, v := range r.Header {
 		headers[k] = strings.Join(v, ",")
@@ -36,28 +84,184 @@ func NewRequestDetails(r *http.Request, readBody bool) RequestDetails {
 		query[k] = strings.Join(v, ",")
 	}
 
-	bodyStr := ""
+	details := RequestDetails{}
+
+	if opts.ReadBody {
+		// Read the body if bodyDebug is enabled, the request body is reset so downstream handlers get all of it
+		body, size, hash, err := readBody(r, opts.MaxBodySize)
+		if err != nil {
+			slog.Warn("Problem reading request body", "error", err)
+		}
+
+		switch {
+		case size == unknownSize:
+			// All we know is there was more than the limit, so the size & hash are left out
+			details.BodyTruncated = true
+		case size > 0:
+			details.BodySize = size
+			details.BodySHA256 = hash
+			details.BodyTruncated = size > int64(len(body))
+		}
+
+		details.setBody(r.Header, body, opts)
+	}
+
+	// Trailers are declared up front, but values are only known once the body has been read
+	var trailers map[string]string
+	if len(r.Trailer) > 0 {
+		trailers = make(map[string]string)
+		for k, v := range r.Trailer {
+			trailers[k] = strings.Join(v, ",")
+		}
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	details.ID = uuid.NewString()
+	details.Method = r.Method
+	details.Path = r.URL.Path
+	details.Proto = r.Proto
+	details.Scheme = scheme
+	details.Host = r.Host
+	details.FullURL = scheme + "://" + r.Host + r.URL.RequestURI()
+	details.RawQuery = r.URL.RawQuery
+	details.RemoteAddr = r.RemoteAddr
+	details.Headers = headers
+	details.HeaderValues = r.Header.Clone()
+	details.Query = query
+	details.QueryValues = r.URL.Query()
+	details.ContentLength = r.ContentLength
+	details.TransferEncoding = r.TransferEncoding
+	details.Trailers = trailers
+	details.TLS = NewTLSDetails(r.TLS)
+	details.Trace = NewTraceContext(r.Header)
+	details.Timestamp = time.Now().Format(time.RFC3339)
+
+	return details
+}
+
+// Fill in the body fields, decoding any Content-Encoding and parsing the body based on its content type
+func (rd *RequestDetails) setBody(header http.Header, body []byte, opts Options) {
+	// Only part of the body was read, so it can't be decompressed or parsed, just shown as it is
+	if rd.BodyTruncated {
+		rd.setBodyString(trimPartialRune(body))
+		rd.BodyError = "body is over the inspect limit, so has been truncated and not decoded or parsed"
 
-	if readBody {
-		// Read the body if bodyDebug is enabled
-		body, err := io.ReadAll(r.Body)
+		return
+	}
+
+	encoding := header.Get("Content-Encoding")
+	if encoding != "" && !strings.EqualFold(encoding, "identity") {
+		decoded, err := DecodeBody(encoding, body)
 		if err != nil {
-			log.Println(err)
+			rd.BodyError = err.Error()
+		} else {
+			body = decoded
+			rd.BodyDecoded = true
 		}
+	}
 
-		bodyStr = string(body)
+	rd.setBodyString(body)
 
-		// Reset the body so it can be read again!
-		r.Body = io.NopCloser(bytes.NewBuffer(body))
+	// No point trying to parse a body we couldn't decode
+	if rd.BodyError != "" {
+		return
 	}
 
-	return RequestDetails{
-		Method:     r.Method,
-		Path:       r.URL.Path,
-		RemoteAddr: r.RemoteAddr,
-		Headers:    headers,
-		Query:      query,
-		Body:       bodyStr,
-		Timestamp:  time.Now().Format(time.RFC3339),
+	contentType := header.Get("Content-Type")
+
+	parsed, err := ParseBody(contentType, body)
+	if err == nil {
+		rd.BodyParsed = parsed
+	} else if !errors.Is(err, ErrNotParsable) {
+		rd.BodyError = "unable to parse body: " + err.Error()
 	}
+
+	form, err := ParseForm(contentType, body, opts.FilePreviewSize)
+	if err == nil {
+		rd.Form = form
+	} else if !errors.Is(err, ErrNotForm) {
+		rd.FormError = err.Error()
+	}
+}
+
+// JSON can't hold binary data, so anything that isn't valid UTF-8 is base64 encoded
+func (rd *RequestDetails) setBodyString(body []byte) {
+	if utf8.Valid(body) {
+		rd.Body = string(body)
+	} else {
+		rd.Body = base64.StdEncoding.EncodeToString(body)
+		rd.BodyBase64 = true
+	}
+}
+
+// Cutting a body short can split a multi-byte character, which would make it look like binary
+// Any incomplete character at the end is dropped, so truncated text is still shown as text
+func trimPartialRune(body []byte) []byte {
+	for i := len(body) - 1; i >= 0 && i >= len(body)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(body[i]) {
+			if !utf8.FullRune(body[i:]) {
+				return body[:i]
+			}
+
+			break
+		}
+	}
+
+	return body
+}
+
+// BodyBytes returns the body as it was before being encoded for output, i.e. with base64 removed
+func (rd RequestDetails) BodyBytes() []byte {
+	if rd.BodyBase64 {
+		body, err := base64.StdEncoding.DecodeString(rd.Body)
+		if err == nil {
+			return body
+		}
+	}
+
+	return []byte(rd.Body)
+}
+
+// Format returns a copy of the details suitable for output in the given format version
+// Older versions have the fields added in later versions removed, so clients see what they expect
+func (rd RequestDetails) Format(version int) RequestDetails {
+	if version < FormatV2 {
+		rd.HeaderValues = nil
+		rd.QueryValues = nil
+	}
+
+	return rd
+}
+
+// AllHeaders returns every value of every header
+// For requests captured before headerValues existed, this falls back to the flat headers
+func (rd RequestDetails) AllHeaders() map[string][]string {
+	if rd.HeaderValues != nil {
+		return rd.HeaderValues
+	}
+
+	return flatToValues(rd.Headers)
+}
+
+// AllQuery returns every value of every query parameter
+// For requests captured before queryValues existed, this falls back to the flat query
+func (rd RequestDetails) AllQuery() map[string][]string {
+	if rd.QueryValues != nil {
+		return rd.QueryValues
+	}
+
+	return flatToValues(rd.Query)
+}
+
+func flatToValues(flat map[string]string) map[string][]string {
+	values := make(map[string][]string, len(flat))
+	for k, v := range flat {
+		values[k] = []string{v}
+	}
+
+	return values
 }
@@ -92,3 +92,67 @@ func TestNewRequestDetails(t *testing.T) {
 		})
 	}
 }
+
+func TestNewRequestDetailsMultiValues(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/test?id=1&id=2,3", nil)
+	req.Header.Add("X-Forwarded-For", "10.0.0.1")
+	req.Header.Add("X-Forwarded-For", "10.0.0.2, 10.0.0.3")
+
+	rd := NewRequestDetails(req, false)
+
+	// Flat form is unchanged, values joined with commas
+	if rd.Query["id"] != "1,2,3" {
+		t.Errorf("expected flat query 1,2,3, got %s", rd.Query["id"])
+	}
+
+	if rd.Headers["X-Forwarded-For"] != "10.0.0.1,10.0.0.2, 10.0.0.3" {
+		t.Errorf("unexpected flat header, got %s", rd.Headers["X-Forwarded-For"])
+	}
+
+	// Lossless form keeps each value
+	if len(rd.QueryValues["id"]) != 2 || rd.QueryValues["id"][1] != "2,3" {
+		t.Errorf("expected query values [1 2,3], got %v", rd.QueryValues["id"])
+	}
+
+	if len(rd.HeaderValues["X-Forwarded-For"]) != 2 {
+		t.Errorf("expected 2 header values, got %v", rd.HeaderValues["X-Forwarded-For"])
+	}
+
+	// Version 1 output hides the lossless form, version 2 includes it
+	if v1 := rd.Format(FormatV1); v1.HeaderValues != nil || v1.QueryValues != nil {
+		t.Errorf("expected v1 format to omit header & query values")
+	}
+
+	if v2 := rd.Format(FormatV2); v2.HeaderValues == nil || v2.QueryValues == nil {
+		t.Errorf("expected v2 format to include header & query values")
+	}
+}
+
+func TestNewRequestDetailsMetadata(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "https://example.com:8443/test?a=1&b=two", strings.NewReader("hello"))
+	req.TransferEncoding = []string{"chunked"}
+	req.ContentLength = -1
+
+	rd := NewRequestDetails(req, true)
+
+	if rd.Proto != "HTTP/1.1" || rd.Scheme != "https" || rd.Host != "example.com:8443" {
+		t.Errorf("unexpected proto, scheme or host: %s %s %s", rd.Proto, rd.Scheme, rd.Host)
+	}
+
+	if rd.FullURL != "https://example.com:8443/test?a=1&b=two" || rd.RawQuery != "a=1&b=two" {
+		t.Errorf("unexpected URL or raw query: %s %s", rd.FullURL, rd.RawQuery)
+	}
+
+	if rd.ContentLength != -1 || len(rd.TransferEncoding) != 1 || rd.TransferEncoding[0] != "chunked" {
+		t.Errorf("unexpected content length or transfer encoding: %d %v", rd.ContentLength, rd.TransferEncoding)
+	}
+
+	if rd.TLS == nil || rd.TLS.Version != "TLS 1.2" || rd.TLS.ServerName != "example.com:8443" {
+		t.Errorf("unexpected TLS details: %+v", rd.TLS)
+	}
+
+	plain := NewRequestDetails(httptest.NewRequest(http.MethodGet, "/test", nil), false)
+	if plain.TLS != nil || plain.Scheme != "http" {
+		t.Errorf("expected no TLS details for plain HTTP request, got %+v", plain.TLS)
+	}
+}
@@ -0,0 +1,107 @@
+package httputil
+
+// ==== httputils: tls.go =============================================================================================
+// Details of the TLS connection a request arrived on
+// ====================================================================================================================
+
+import (
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TLSDetails holds the negotiated parameters of a TLS connection
+type TLSDetails struct {
+	Version     string `json:"version,omitempty"`
+	CipherSuite string `json:"cipherSuite,omitempty"`
+	ALPN        string `json:"alpn,omitempty"`
+	ServerName  string `json:"serverName,omitempty"`
+	Resumed     bool   `json:"resumed,omitempty"`
+	// ClientCertificates is the chain presented by the client when using mTLS, leaf first
+	ClientCertificates []CertificateDetails `json:"clientCertificates,omitempty"`
+	// ClientVerified is true when the client chain was verified against the configured CA
+	ClientVerified bool `json:"clientVerified,omitempty"`
+}
+
+// CertificateDetails holds the interesting parts of an x509 certificate
+type CertificateDetails struct {
+	Subject        string   `json:"subject"`
+	Issuer         string   `json:"issuer"`
+	SerialNumber   string   `json:"serialNumber"`
+	NotBefore      string   `json:"notBefore"`
+	NotAfter       string   `json:"notAfter"`
+	Expired        bool     `json:"expired"`
+	DNSNames       []string `json:"dnsNames,omitempty"`
+	IPAddresses    []string `json:"ipAddresses,omitempty"`
+	EmailAddresses []string `json:"emailAddresses,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+	SHA1           string   `json:"sha1"`
+	SHA256         string   `json:"sha256"`
+}
+
+// NewTLSDetails creates TLSDetails from a connection state, returns nil if there is no TLS
+func NewTLSDetails(state *tls.ConnectionState) *TLSDetails {
+	if state == nil {
+		return nil
+	}
+
+	details := &TLSDetails{
+		Version:        tls.VersionName(state.Version),
+		CipherSuite:    tls.CipherSuiteName(state.CipherSuite),
+		ALPN:           state.NegotiatedProtocol,
+		ServerName:     state.ServerName,
+		Resumed:        state.DidResume,
+		ClientVerified: len(state.VerifiedChains) > 0,
+	}
+
+	for _, cert := range state.PeerCertificates {
+		details.ClientCertificates = append(details.ClientCertificates, NewCertificateDetails(cert))
+	}
+
+	return details
+}
+
+// NewCertificateDetails extracts the details of a certificate
+func NewCertificateDetails(cert *x509.Certificate) CertificateDetails {
+	ips := []string{}
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+
+	uris := []string{}
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	sha1Sum := sha1.Sum(cert.Raw) //nolint:gosec
+	sha256Sum := sha256.Sum256(cert.Raw)
+
+	return CertificateDetails{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		SerialNumber:   fingerprint(cert.SerialNumber.Bytes()),
+		NotBefore:      cert.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:       cert.NotAfter.UTC().Format(time.RFC3339),
+		Expired:        time.Now().After(cert.NotAfter),
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    ips,
+		EmailAddresses: cert.EmailAddresses,
+		URIs:           uris,
+		SHA1:           fingerprint(sha1Sum[:]),
+		SHA256:         fingerprint(sha256Sum[:]),
+	}
+}
+
+// Format bytes as colon separated hex, the same way openssl displays fingerprints & serials
+func fingerprint(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = fmt.Sprintf("%02X", v)
+	}
+
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,83 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package httputil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewTLSDetailsWithClientCert(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/default/sa/app")
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(0x1234),
+		Subject:        pkix.Name{CommonName: "client", Organization: []string{"Team"}},
+		NotBefore:      time.Now().Add(-2 * time.Hour),
+		NotAfter:       time.Now().Add(-1 * time.Hour),
+		DNSNames:       []string{"client.local"},
+		IPAddresses:    []net.IP{net.ParseIP("10.1.2.3")},
+		EmailAddresses: []string{"someone@example.net"},
+		URIs:           []*url.URL{spiffe},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+
+	details := NewTLSDetails(&tls.ConnectionState{
+		Version:          tls.VersionTLS13,
+		CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+		PeerCertificates: []*x509.Certificate{cert},
+	})
+
+	if details.Version != "TLS 1.3" || details.CipherSuite != "TLS_AES_128_GCM_SHA256" {
+		t.Errorf("unexpected version or cipher suite: %s %s", details.Version, details.CipherSuite)
+	}
+
+	if details.ClientVerified {
+		t.Errorf("expected client cert to not be verified")
+	}
+
+	if len(details.ClientCertificates) != 1 {
+		t.Fatalf("expected 1 client certificate, got %d", len(details.ClientCertificates))
+	}
+
+	cd := details.ClientCertificates[0]
+	if cd.Subject != "CN=client,O=Team" || cd.Issuer != "CN=client,O=Team" {
+		t.Errorf("unexpected subject or issuer: %s %s", cd.Subject, cd.Issuer)
+	}
+
+	if cd.SerialNumber != "12:34" {
+		t.Errorf("unexpected serial number: %s", cd.SerialNumber)
+	}
+
+	if !cd.Expired {
+		t.Errorf("expected certificate to be expired")
+	}
+
+	if len(cd.DNSNames) != 1 || len(cd.IPAddresses) != 1 || cd.IPAddresses[0] != "10.1.2.3" ||
+		len(cd.EmailAddresses) != 1 || len(cd.URIs) != 1 || cd.URIs[0] != spiffe.String() {
+		t.Errorf("unexpected SANs: %+v", cd)
+	}
+
+	if len(cd.SHA256) != 95 || len(cd.SHA1) != 59 {
+		t.Errorf("unexpected fingerprint lengths: %s %s", cd.SHA1, cd.SHA256)
+	}
+}
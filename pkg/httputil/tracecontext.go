@@ -0,0 +1,207 @@
+package httputil
+
+// ==== httputils: tracecontext.go ====================================================================================
+// Parse W3C trace context & B3 headers, to show how trace context was propagated to us
+// ====================================================================================================================
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TraceContext holds the trace propagation headers found on a request
+type TraceContext struct {
+	TraceParent *TraceParent      `json:"traceparent,omitempty"`
+	TraceState  []TraceStateEntry `json:"tracestate,omitempty"`
+	B3          *B3               `json:"b3,omitempty"`
+	// Errors lists any problems found with the headers, e.g. an invalid traceparent
+	Errors []string `json:"errors,omitempty"`
+}
+
+// TraceParent is a parsed W3C traceparent header, see https://www.w3.org/TR/trace-context/#traceparent-header
+type TraceParent struct {
+	Version  string `json:"version"`
+	TraceID  string `json:"traceId"`
+	ParentID string `json:"parentId"`
+	Flags    string `json:"flags"`
+	Sampled  bool   `json:"sampled"`
+}
+
+// TraceStateEntry is one vendor entry from a W3C tracestate header, these are kept in order as it matters
+type TraceStateEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// B3 holds Zipkin B3 propagation details, from either the single b3 header or the multiple X-B3-* headers
+type B3 struct {
+	// Format is either "single" or "multi"
+	Format       string `json:"format"`
+	TraceID      string `json:"traceId,omitempty"`
+	SpanID       string `json:"spanId,omitempty"`
+	ParentSpanID string `json:"parentSpanId,omitempty"`
+	// Sampled is "1", "0" or "d" for debug, empty when the decision was deferred
+	Sampled string `json:"sampled,omitempty"`
+}
+
+var (
+	traceParentRegex = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+	b3TraceIDRegex   = regexp.MustCompile(`^([0-9a-f]{16}|[0-9a-f]{32})$`)
+	b3SpanIDRegex    = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+// NewTraceContext parses any trace propagation headers, returns nil if there are none
+func NewTraceContext(header http.Header) *TraceContext {
+	tc := &TraceContext{}
+
+	if traceParent := header.Get("Traceparent"); traceParent != "" {
+		tc.parseTraceParent(traceParent)
+	}
+
+	// Multiple tracestate headers are allowed, and are treated as one list
+	if traceState := header.Values("Tracestate"); len(traceState) > 0 {
+		tc.parseTraceState(strings.Join(traceState, ","))
+	}
+
+	if single := header.Get("B3"); single != "" {
+		tc.parseB3Single(single)
+	} else if header.Get("X-B3-Traceid") != "" || header.Get("X-B3-Sampled") != "" || header.Get("X-B3-Flags") != "" {
+		tc.parseB3Multi(header)
+	}
+
+	if tc.TraceParent == nil && tc.TraceState == nil && tc.B3 == nil && tc.Errors == nil {
+		return nil
+	}
+
+	return tc
+}
+
+func (tc *TraceContext) parseTraceParent(value string) {
+	match := traceParentRegex.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		tc.Errors = append(tc.Errors, "traceparent is not in the format version-traceid-parentid-flags")
+		return
+	}
+
+	version, traceID, parentID, flags := match[1], match[2], match[3], match[4]
+
+	switch {
+	case version == "ff":
+		tc.Errors = append(tc.Errors, "traceparent version ff is invalid")
+		return
+	case version == "00" && match[5] != "":
+		tc.Errors = append(tc.Errors, "traceparent version 00 must not have extra fields")
+		return
+	case traceID == strings.Repeat("0", 32):
+		tc.Errors = append(tc.Errors, "traceparent trace ID is all zeros")
+		return
+	case parentID == strings.Repeat("0", 16):
+		tc.Errors = append(tc.Errors, "traceparent parent ID is all zeros")
+		return
+	}
+
+	// Only the lowest bit of the flags is defined, meaning the caller sampled this trace
+	flagBits, _ := strconv.ParseUint(flags, 16, 8)
+
+	tc.TraceParent = &TraceParent{
+		Version:  version,
+		TraceID:  traceID,
+		ParentID: parentID,
+		Flags:    flags,
+		Sampled:  flagBits&1 == 1,
+	}
+}
+
+func (tc *TraceContext) parseTraceState(value string) {
+	for _, member := range strings.Split(value, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+
+		key, val, found := strings.Cut(member, "=")
+		if !found || key == "" {
+			tc.Errors = append(tc.Errors, "tracestate entry is not in the format key=value: "+member)
+			continue
+		}
+
+		tc.TraceState = append(tc.TraceState, TraceStateEntry{Key: key, Value: val})
+	}
+
+	if len(tc.TraceState) > 32 {
+		tc.Errors = append(tc.Errors, "tracestate has more than 32 entries")
+	}
+}
+
+// The single header is {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}, or just the sampling state
+func (tc *TraceContext) parseB3Single(value string) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	b3 := &B3{Format: "single"}
+
+	switch len(parts) {
+	case 1:
+		b3.Sampled = parts[0]
+	case 2, 3, 4:
+		b3.TraceID, b3.SpanID = parts[0], parts[1]
+		if len(parts) > 2 {
+			b3.Sampled = parts[2]
+		}
+
+		if len(parts) > 3 {
+			b3.ParentSpanID = parts[3]
+		}
+	default:
+		tc.Errors = append(tc.Errors, "b3 header has too many fields")
+		return
+	}
+
+	tc.B3 = b3
+	tc.validateB3()
+}
+
+func (tc *TraceContext) parseB3Multi(header http.Header) {
+	tc.B3 = &B3{
+		Format:       "multi",
+		TraceID:      header.Get("X-B3-Traceid"),
+		SpanID:       header.Get("X-B3-Spanid"),
+		ParentSpanID: header.Get("X-B3-Parentspanid"),
+		Sampled:      header.Get("X-B3-Sampled"),
+	}
+
+	// The flags header is only used to signal debug, which implies sampled
+	if header.Get("X-B3-Flags") == "1" {
+		tc.B3.Sampled = "d"
+	}
+
+	// Some old tracers send true & false rather than 1 & 0
+	switch strings.ToLower(tc.B3.Sampled) {
+	case "true":
+		tc.B3.Sampled = "1"
+	case "false":
+		tc.B3.Sampled = "0"
+	}
+
+	tc.validateB3()
+}
+
+func (tc *TraceContext) validateB3() {
+	b3 := tc.B3
+
+	if b3.TraceID != "" && !b3TraceIDRegex.MatchString(b3.TraceID) {
+		tc.Errors = append(tc.Errors, "b3 trace ID must be 16 or 32 lower case hex characters")
+	}
+
+	if b3.TraceID != "" && !b3SpanIDRegex.MatchString(b3.SpanID) {
+		tc.Errors = append(tc.Errors, "b3 span ID must be 16 lower case hex characters")
+	}
+
+	if b3.ParentSpanID != "" && !b3SpanIDRegex.MatchString(b3.ParentSpanID) {
+		tc.Errors = append(tc.Errors, "b3 parent span ID must be 16 lower case hex characters")
+	}
+
+	if b3.Sampled != "" && b3.Sampled != "0" && b3.Sampled != "1" && b3.Sampled != "d" {
+		tc.Errors = append(tc.Errors, "b3 sampling state must be 0, 1 or d")
+	}
+}
@@ -0,0 +1,98 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package httputil
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNewTraceContextNone(t *testing.T) {
+	if tc := NewTraceContext(http.Header{"Accept": {"*/*"}}); tc != nil {
+		t.Errorf("expected nil trace context with no trace headers, got %+v", tc)
+	}
+}
+
+func TestNewTraceContextW3C(t *testing.T) {
+	header := http.Header{}
+	header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Add("Tracestate", "rojo=00f067aa0ba902b7")
+	header.Add("Tracestate", "congo=t61rcWkgMzE")
+
+	tc := NewTraceContext(header)
+	if tc == nil || tc.TraceParent == nil {
+		t.Fatalf("expected traceparent to be parsed, got %+v", tc)
+	}
+
+	tp := tc.TraceParent
+	if tp.Version != "00" || tp.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || tp.ParentID != "00f067aa0ba902b7" || !tp.Sampled {
+		t.Errorf("unexpected traceparent: %+v", tp)
+	}
+
+	if len(tc.TraceState) != 2 || tc.TraceState[0].Key != "rojo" || tc.TraceState[1].Value != "t61rcWkgMzE" {
+		t.Errorf("unexpected tracestate: %+v", tc.TraceState)
+	}
+
+	if len(tc.Errors) != 0 {
+		t.Errorf("expected no errors, got %v", tc.Errors)
+	}
+}
+
+func TestNewTraceContextW3CInvalid(t *testing.T) {
+	tests := []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+
+	for _, value := range tests {
+		tc := NewTraceContext(http.Header{"Traceparent": {value}})
+		if tc == nil || tc.TraceParent != nil || len(tc.Errors) != 1 {
+			t.Errorf("expected %s to be rejected, got %+v", value, tc)
+		}
+	}
+
+	// Future versions can have extra fields
+	tc := NewTraceContext(http.Header{"Traceparent": {"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0a-extra"}})
+	if tc.TraceParent == nil || tc.TraceParent.Sampled {
+		t.Errorf("expected future version to be accepted & not sampled, got %+v", tc)
+	}
+}
+
+func TestNewTraceContextB3(t *testing.T) {
+	single := NewTraceContext(http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90"}})
+	if single == nil || single.B3 == nil {
+		t.Fatalf("expected b3 single header to be parsed")
+	}
+
+	b3 := single.B3
+	if b3.Format != "single" || b3.TraceID != "80f198ee56343ba864fe8b2a57d3eff7" || b3.SpanID != "e457b5a2e4d86bd1" ||
+		b3.Sampled != "1" || b3.ParentSpanID != "05e3ac9a4f6e3b90" || len(single.Errors) != 0 {
+		t.Errorf("unexpected b3: %+v %v", b3, single.Errors)
+	}
+
+	if deny := NewTraceContext(http.Header{"B3": {"0"}}); deny.B3.Sampled != "0" || deny.B3.TraceID != "" {
+		t.Errorf("expected sampling only b3 header, got %+v", deny.B3)
+	}
+
+	header := http.Header{}
+	header.Set("X-B3-TraceId", "463ac35c9f6413ad")
+	header.Set("X-B3-SpanId", "a2fb4a1d1a96d312")
+	header.Set("X-B3-Sampled", "true")
+
+	multi := NewTraceContext(header)
+	if multi.B3.Format != "multi" || multi.B3.TraceID != "463ac35c9f6413ad" || multi.B3.Sampled != "1" || len(multi.Errors) != 0 {
+		t.Errorf("unexpected b3 multi: %+v %v", multi.B3, multi.Errors)
+	}
+
+	header.Set("X-B3-SpanId", "nothex")
+	header.Set("X-B3-Flags", "1")
+
+	bad := NewTraceContext(header)
+	if bad.B3.Sampled != "d" || len(bad.Errors) != 1 {
+		t.Errorf("expected debug flag & span ID error, got %+v %v", bad.B3, bad.Errors)
+	}
+}
@@ -0,0 +1,136 @@
+package probe
+
+// ==== probe: probe.go ===============================================================================================
+// Health probes whose state can be changed at runtime, to simulate failures when testing probe & health check config
+// ====================================================================================================================
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Failure describes how a probe should fail
+type Failure struct {
+	// How long to fail for, zero means until the probe is reset
+	Duration time.Duration
+	// Fail only every Nth check rather than every check, zero or one means every check fails
+	Every int
+	// Status code returned by failing checks, defaults to 503
+	Status int
+}
+
+// State is a snapshot of a probe, as returned by the admin API
+type State struct {
+	Name    string `json:"name"`
+	Failing bool   `json:"failing"`
+	// Until is when the failure ends, nil if it lasts until reset
+	Until    *time.Time `json:"until,omitempty"`
+	Every    int        `json:"every,omitempty"`
+	Status   int        `json:"status,omitempty"`
+	Checks   int        `json:"checks"`
+	Failures int        `json:"failures"`
+}
+
+// Probe is a health check which passes unless told to fail, it's safe for concurrent use
+type Probe struct {
+	mu       sync.Mutex
+	name     string
+	failure  *Failure
+	until    time.Time
+	count    int
+	checks   int
+	failures int
+	now      func() time.Time
+}
+
+// New creates a probe which is passing
+func New(name string) *Probe {
+	return &Probe{
+		name: name,
+		now:  time.Now,
+	}
+}
+
+// Check runs the probe, returning the status code to respond with
+func (p *Probe) Check() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+	p.checks++
+
+	if p.failure == nil {
+		return http.StatusOK
+	}
+
+	// Count checks since the failure was set, so every Nth is predictable
+	p.count++
+	if p.failure.Every > 1 && p.count%p.failure.Every != 0 {
+		return http.StatusOK
+	}
+
+	p.failures++
+
+	return p.failure.Status
+}
+
+// Fail makes the probe start failing, replacing any previous failure
+func (p *Probe) Fail(f Failure) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if f.Status == 0 {
+		f.Status = http.StatusServiceUnavailable
+	}
+
+	p.failure = &f
+	p.count = 0
+	p.until = time.Time{}
+
+	if f.Duration > 0 {
+		p.until = p.now().Add(f.Duration)
+	}
+}
+
+// Reset makes the probe pass again
+func (p *Probe) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failure = nil
+}
+
+// State returns a snapshot of the probe
+func (p *Probe) State() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire()
+
+	state := State{
+		Name:     p.name,
+		Failing:  p.failure != nil,
+		Checks:   p.checks,
+		Failures: p.failures,
+	}
+
+	if p.failure != nil {
+		state.Every = p.failure.Every
+		state.Status = p.failure.Status
+
+		if !p.until.IsZero() {
+			until := p.until
+			state.Until = &until
+		}
+	}
+
+	return state
+}
+
+// Clear the failure once its duration has passed, must be called with the lock held
+func (p *Probe) expire() {
+	if p.failure != nil && !p.until.IsZero() && !p.now().Before(p.until) {
+		p.failure = nil
+	}
+}
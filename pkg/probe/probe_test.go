@@ -0,0 +1,90 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package probe
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestProbePassesByDefault(t *testing.T) {
+	p := New("live")
+
+	if status := p.Check(); status != http.StatusOK {
+		t.Errorf("expected new probe to pass, got %d", status)
+	}
+
+	state := p.State()
+	if state.Name != "live" || state.Failing || state.Checks != 1 || state.Failures != 0 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+}
+
+func TestProbeFailUntilReset(t *testing.T) {
+	p := New("ready")
+	p.Fail(Failure{Status: http.StatusInternalServerError})
+
+	for range 3 {
+		if status := p.Check(); status != http.StatusInternalServerError {
+			t.Errorf("expected probe to fail with 500, got %d", status)
+		}
+	}
+
+	if state := p.State(); !state.Failing || state.Until != nil || state.Failures != 3 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+
+	p.Reset()
+
+	if status := p.Check(); status != http.StatusOK {
+		t.Errorf("expected probe to pass after reset, got %d", status)
+	}
+}
+
+func TestProbeFailForDuration(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	p := New("ready")
+	p.now = func() time.Time { return now }
+
+	p.Fail(Failure{Duration: 30 * time.Second})
+
+	if status := p.Check(); status != http.StatusServiceUnavailable {
+		t.Errorf("expected probe to fail with default 503, got %d", status)
+	}
+
+	if state := p.State(); state.Until == nil || !state.Until.Equal(now.Add(30*time.Second)) {
+		t.Errorf("expected failure to end in 30s, got %+v", state)
+	}
+
+	now = now.Add(30 * time.Second)
+
+	if status := p.Check(); status != http.StatusOK {
+		t.Errorf("expected probe to pass once duration has passed, got %d", status)
+	}
+
+	if state := p.State(); state.Failing {
+		t.Errorf("expected failure to have expired, got %+v", state)
+	}
+}
+
+func TestProbeFailEveryNth(t *testing.T) {
+	p := New("live")
+	p.Fail(Failure{Every: 3})
+
+	results := []int{}
+	for range 6 {
+		results = append(results, p.Check())
+	}
+
+	expected := []int{200, 200, 503, 200, 200, 503}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, results)
+		}
+	}
+
+	if state := p.State(); state.Every != 3 || state.Checks != 6 || state.Failures != 2 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+}
@@ -0,0 +1,397 @@
+package redact
+
+// ==== redact: redact.go =============================================================================================
+// Redact sensitive values such as tokens, cookies & passwords from inspected requests
+// ====================================================================================================================
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+)
+
+// Marker replaces any value which has been redacted
+const Marker = "[REDACTED]"
+
+// DefaultHeaders are always redacted, along with any header matching the sensitive name pattern
+var DefaultHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// Names of headers, query parameters & form fields which look like they hold secrets
+var sensitiveName = regexp.MustCompile(`(?i)(token|secret|passw(or)?d|api[-_]?key|signature|credential)|^(key|sig|pwd|auth|session)$`)
+
+// Names of cookies which look like they hold sessions, on top of the sensitive names e.g. JSESSIONID or connect.sid
+var sensitiveCookie = regexp.MustCompile(`(?i)sess|sid$|jwt`)
+
+// Redactor removes sensitive values from RequestDetails, it is safe for concurrent use once created
+type Redactor struct {
+	headers   map[string]bool
+	jsonPaths [][]string
+	patterns  []*regexp.Regexp
+}
+
+// New creates a Redactor with the default rules, plus extra header names, JSON paths & regex patterns
+// JSON paths are dot separated keys from the root of the body, with * matching any key or array index
+// e.g. "password", "user.token" or "cards.*.number"
+func New(headers []string, jsonPaths []string, patterns []string) (*Redactor, error) {
+	r := &Redactor{headers: map[string]bool{}}
+
+	for _, h := range append(DefaultHeaders, headers...) {
+		r.headers[http.CanonicalHeaderKey(strings.TrimSpace(h))] = true
+	}
+
+	for _, p := range jsonPaths {
+		r.jsonPaths = append(r.jsonPaths, strings.Split(strings.TrimPrefix(p, "$."), "."))
+	}
+
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %s: %w", p, err)
+		}
+
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+// Apply redacts the request details in place
+func (r *Redactor) Apply(rd *httputil.RequestDetails) {
+	r.redactHeaders(rd.Headers, rd.HeaderValues)
+	r.redactHeaders(rd.Trailers, nil)
+
+	// Query parameters
+	for name := range rd.Query {
+		if sensitiveName.MatchString(name) {
+			rd.Query[name] = Marker
+
+			if rd.QueryValues != nil {
+				rd.QueryValues[name] = markers(len(rd.QueryValues[name]))
+			}
+		}
+	}
+
+	if rd.RawQuery != "" {
+		rawQuery := redactEncoded(rd.RawQuery)
+		rd.FullURL = strings.Replace(rd.FullURL, "?"+rd.RawQuery, "?"+rawQuery, 1)
+		rd.RawQuery = rawQuery
+	}
+
+	r.redactBody(rd)
+
+	// Patterns are applied last, to everything that's left
+	if len(r.patterns) == 0 {
+		return
+	}
+
+	rd.FullURL = r.replacePatterns(rd.FullURL)
+	rd.RawQuery = r.replacePatterns(rd.RawQuery)
+
+	multiValues := []map[string][]string{rd.HeaderValues, rd.QueryValues}
+	if rd.Form != nil {
+		multiValues = append(multiValues, rd.Form.Fields)
+	}
+
+	for _, values := range multiValues {
+		for name := range values {
+			for i := range values[name] {
+				values[name][i] = r.replacePatterns(values[name][i])
+			}
+		}
+	}
+
+	for _, flat := range []map[string]string{rd.Headers, rd.Query, rd.Trailers} {
+		for name := range flat {
+			flat[name] = r.replacePatterns(flat[name])
+		}
+	}
+
+	if !rd.BodyBase64 {
+		rd.Body = r.replacePatterns(rd.Body)
+	}
+
+	rd.BodyParsed = r.replaceParsed(rd.BodyParsed)
+}
+
+// URI redacts a request URI, e.g. /path?token=abc, as used in access logs
+func (r *Redactor) URI(uri string) string {
+	if path, query, found := strings.Cut(uri, "?"); found {
+		uri = path + "?" + redactEncoded(query)
+	}
+
+	return r.replacePatterns(uri)
+}
+
+// Cookie redacts the value of a cookie when its name looks like a session or secret, or it matches a pattern
+// Other cookies, such as load balancer affinity, are kept as they are useful when debugging
+func (r *Redactor) Cookie(name string, value string) string {
+	if sensitiveName.MatchString(name) || sensitiveCookie.MatchString(name) {
+		return Marker
+	}
+
+	return r.replacePatterns(value)
+}
+
+// Headers are redacted in both the flat & multi-value maps, either can be nil
+func (r *Redactor) redactHeaders(flat map[string]string, values map[string][]string) {
+	for name := range flat {
+		if !r.headers[http.CanonicalHeaderKey(name)] && !sensitiveName.MatchString(name) {
+			continue
+		}
+
+		if values[name] == nil {
+			flat[name] = redactHeaderValue(name, flat[name])
+
+			continue
+		}
+
+		for i, v := range values[name] {
+			values[name][i] = redactHeaderValue(name, v)
+		}
+
+		flat[name] = strings.Join(values[name], ",")
+	}
+}
+
+// Keep the parts of a header value that are useful when debugging but not secret, e.g. the auth scheme or cookie names
+func redactHeaderValue(name string, value string) string {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization":
+		if scheme, _, found := strings.Cut(value, " "); found {
+			return scheme + " " + Marker
+		}
+
+	case "Cookie":
+		cookies := strings.Split(value, ";")
+		for i, c := range cookies {
+			cookieName, _, _ := strings.Cut(strings.TrimSpace(c), "=")
+			cookies[i] = cookieName + "=" + Marker
+		}
+
+		return strings.Join(cookies, "; ")
+
+	case "Set-Cookie":
+		cookieName, _, _ := strings.Cut(value, "=")
+
+		return cookieName + "=" + Marker
+	}
+
+	return Marker
+}
+
+// Redact the values of sensitive names in a urlencoded string, keeping the order & everything else as it was
+func redactEncoded(encoded string) string {
+	pairs := strings.Split(encoded, "&")
+	for i, pair := range pairs {
+		rawName, _, hasValue := strings.Cut(pair, "=")
+
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+
+		if hasValue && sensitiveName.MatchString(name) {
+			pairs[i] = rawName + "=" + url.QueryEscape(Marker)
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// Redact form fields & JSON paths in the body, keeping the raw body in step with the parsed versions
+//
+//nolint:cyclop
+func (r *Redactor) redactBody(rd *httputil.RequestDetails) {
+	mediaType, params, _ := mime.ParseMediaType(rd.Headers["Content-Type"])
+
+	if rd.Form != nil {
+		secrets := 0
+
+		for name, values := range rd.Form.Fields {
+			if sensitiveName.MatchString(name) {
+				secrets += len(values)
+				rd.Form.Fields[name] = markers(len(values))
+			}
+		}
+
+		if mediaType == "application/x-www-form-urlencoded" {
+			rd.Body = redactEncoded(rd.Body)
+		} else if secrets > 0 && !rd.BodyBase64 {
+			body, redacted := redactMultipart(rd.Body, params["boundary"])
+
+			// If the parts couldn't all be found again, don't risk leaving a secret in the body
+			if redacted < secrets {
+				body = Marker
+			}
+
+			rd.Body = body
+		}
+	}
+
+	if len(r.jsonPaths) == 0 {
+		return
+	}
+
+	// Truncated or invalid JSON can't be walked, so the whole body goes rather than risk leaking what the paths cover
+	if rd.BodyParsed == nil {
+		if rd.Body != "" && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+			rd.Body = Marker
+			rd.BodyBase64 = false
+		}
+
+		return
+	}
+
+	changed := false
+	for _, path := range r.jsonPaths {
+		if redactPath(rd.BodyParsed, path) {
+			changed = true
+		}
+	}
+
+	// XML bodies are parsed into nodes, rather than maps & slices, so only JSON can change
+	if changed {
+		if body, err := json.Marshal(rd.BodyParsed); err == nil {
+			rd.Body = string(body)
+		}
+	}
+}
+
+// Replace the values of sensitive fields in a multipart body, walking the parts between the boundaries
+// File parts are left alone, as they are for the parsed form. Returns the body & how many values were redacted
+func redactMultipart(body string, boundary string) (string, int) {
+	if boundary == "" {
+		return body, 0
+	}
+
+	// Every delimiter after the first is preceded by CRLF, adding one to the start means they can all be split the same
+	delimiter := "\r\n--" + boundary
+	parts := strings.Split("\r\n"+body, delimiter)
+	redacted := 0
+
+	// The first is the preamble, and the closing delimiter leaves a part starting with "--"
+	for i := 1; i < len(parts); i++ {
+		headers, _, found := strings.Cut(parts[i], "\r\n\r\n")
+		if !found || strings.HasPrefix(parts[i], "--") {
+			continue
+		}
+
+		if name, isFile := partName(headers); !isFile && sensitiveName.MatchString(name) {
+			parts[i] = headers + "\r\n\r\n" + Marker
+			redacted++
+		}
+	}
+
+	return strings.TrimPrefix(strings.Join(parts, delimiter), "\r\n"), redacted
+}
+
+// Get the field name from the headers of a multipart part, and whether the part is a file
+func partName(headers string) (string, bool) {
+	for _, line := range strings.Split(headers, "\r\n") {
+		name, value, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(strings.TrimSpace(name), "Content-Disposition") {
+			continue
+		}
+
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			return "", false
+		}
+
+		_, isFile := params["filename"]
+
+		return params["name"], isFile
+	}
+
+	return "", false
+}
+
+// Walk a parsed JSON value following the path, replacing whatever is at the end with the marker
+func redactPath(value any, path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+
+	key, rest := path[0], path[1:]
+	changed := false
+
+	switch v := value.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if key != "*" && key != k {
+				continue
+			}
+
+			if len(rest) == 0 {
+				v[k] = Marker
+				changed = true
+			} else if redactPath(child, rest) {
+				changed = true
+			}
+		}
+
+	case []any:
+		for i, child := range v {
+			if key != "*" && key != fmt.Sprint(i) {
+				continue
+			}
+
+			if len(rest) == 0 {
+				v[i] = Marker
+				changed = true
+			} else if redactPath(child, rest) {
+				changed = true
+			}
+		}
+	}
+
+	return changed
+}
+
+func (r *Redactor) replacePatterns(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, Marker)
+	}
+
+	return s
+}
+
+// Apply the patterns to every string in a parsed JSON body
+func (r *Redactor) replaceParsed(value any) any {
+	switch v := value.(type) {
+	case string:
+		return r.replacePatterns(v)
+
+	case map[string]any:
+		for k, child := range v {
+			v[k] = r.replaceParsed(child)
+		}
+
+	case []any:
+		for i, child := range v {
+			v[i] = r.replaceParsed(child)
+		}
+	}
+
+	return value
+}
+
+func markers(n int) []string {
+	m := make([]string, n)
+	for i := range m {
+		m[i] = Marker
+	}
+
+	return m
+}
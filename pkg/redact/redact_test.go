@@ -0,0 +1,228 @@
+// Created by Copilot, don't blame me if the code is shonky!
+
+package redact
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/benc-uk/http-toolkit/pkg/httputil"
+)
+
+func inspect(t *testing.T, r *Redactor, req *http.Request) httputil.RequestDetails {
+	t.Helper()
+
+	rd := httputil.NewRequestDetails(req, true)
+	r.Apply(&rd)
+
+	return rd
+}
+
+func TestRedactHeadersAndQuery(t *testing.T) {
+	r, err := New([]string{"x-tenant"}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test?api_key=abc123&page=2&access_token=xyz", nil)
+	req.Header.Set("Authorization", "Bearer eyJhbGciOi")
+	req.Header.Set("Cookie", "session=s3cr3t; theme=dark")
+	req.Header.Set("X-Api-Key", "k-123")
+	req.Header.Set("X-Tenant", "acme")
+	req.Header.Set("Accept", "text/plain")
+
+	rd := inspect(t, r, req)
+
+	if rd.Headers["Authorization"] != "Bearer [REDACTED]" {
+		t.Errorf("expected auth scheme to be kept, got %s", rd.Headers["Authorization"])
+	}
+
+	if rd.HeaderValues["Authorization"][0] != "Bearer [REDACTED]" {
+		t.Errorf("expected header values to be redacted, got %v", rd.HeaderValues["Authorization"])
+	}
+
+	if rd.Headers["Cookie"] != "session=[REDACTED]; theme=[REDACTED]" {
+		t.Errorf("expected cookie names to be kept, got %s", rd.Headers["Cookie"])
+	}
+
+	if rd.Headers["X-Api-Key"] != Marker || rd.Headers["X-Tenant"] != Marker {
+		t.Errorf("expected API key & configured headers to be redacted, got %v", rd.Headers)
+	}
+
+	if rd.Headers["Accept"] != "text/plain" {
+		t.Errorf("expected other headers to be left alone, got %s", rd.Headers["Accept"])
+	}
+
+	if rd.Query["api_key"] != Marker || rd.QueryValues["access_token"][0] != Marker || rd.Query["page"] != "2" {
+		t.Errorf("unexpected query: %v %v", rd.Query, rd.QueryValues)
+	}
+
+	wantRaw := "api_key=%5BREDACTED%5D&page=2&access_token=%5BREDACTED%5D"
+	if rd.RawQuery != wantRaw || !strings.HasSuffix(rd.FullURL, "/test?"+wantRaw) {
+		t.Errorf("unexpected raw query or URL: %s %s", rd.RawQuery, rd.FullURL)
+	}
+}
+
+func TestRedactJSONPaths(t *testing.T) {
+	r, _ := New(nil, []string{"password", "$.cards.*.number", "missing.path"}, nil)
+
+	body := `{"user":"bob","password":"hunter2","cards":[{"number":"4111","exp":"12/30"},{"number":"5500"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	rd := inspect(t, r, req)
+
+	if strings.Contains(rd.Body, "hunter2") || strings.Contains(rd.Body, "4111") || strings.Contains(rd.Body, "5500") {
+		t.Errorf("expected secrets to be removed from body, got %s", rd.Body)
+	}
+
+	parsed := rd.BodyParsed.(map[string]any)
+	card := parsed["cards"].([]any)[0].(map[string]any)
+
+	if parsed["password"] != Marker || card["number"] != Marker || card["exp"] != "12/30" || parsed["user"] != "bob" {
+		t.Errorf("unexpected parsed body: %v", parsed)
+	}
+}
+
+func TestRedactPatterns(t *testing.T) {
+	r, err := New(nil, nil, []string{`\d{4}-\d{4}-\d{4}-\d{4}`})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/pay?ref=1234-5678-9012-3456", strings.NewReader(`{"note":"card 1111-2222-3333-4444"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Card", "9999-8888-7777-6666")
+
+	rd := inspect(t, r, req)
+
+	if rd.Body != `{"note":"card [REDACTED]"}` || rd.BodyParsed.(map[string]any)["note"] != "card [REDACTED]" {
+		t.Errorf("expected body to be redacted, got %s %v", rd.Body, rd.BodyParsed)
+	}
+
+	if rd.Headers["X-Card"] != Marker || rd.Query["ref"] != Marker || strings.Contains(rd.FullURL, "1234") {
+		t.Errorf("expected headers, query & URL to be redacted: %v %v %s", rd.Headers, rd.Query, rd.FullURL)
+	}
+
+	if _, err := New(nil, nil, []string{"("}); err == nil {
+		t.Errorf("expected error for invalid pattern")
+	}
+}
+
+func TestRedactForms(t *testing.T) {
+	r, _ := New(nil, nil, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("user=bob&password=hunter2"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rd := inspect(t, r, req)
+
+	if rd.Body != "user=bob&password=%5BREDACTED%5D" || rd.Form.Fields["password"][0] != Marker {
+		t.Errorf("expected urlencoded password to be redacted, got %s %v", rd.Body, rd.Form.Fields)
+	}
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	_ = mw.WriteField("user", "bob")
+	_ = mw.WriteField("client_secret", "shhh-1234")
+	_ = mw.Close()
+
+	req = httptest.NewRequest(http.MethodPost, "/login", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	rd = inspect(t, r, req)
+
+	if strings.Contains(rd.Body, "shhh-1234") || rd.Form.Fields["client_secret"][0] != Marker || rd.Form.Fields["user"][0] != "bob" {
+		t.Errorf("expected multipart secret to be redacted, got %s %v", rd.Body, rd.Form.Fields)
+	}
+}
+
+func TestRedactMultipartShortValue(t *testing.T) {
+	r, _ := New(nil, nil, nil)
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	_ = mw.WriteField("user", "a1b1")
+	_ = mw.WriteField("password", "1")
+	fw, _ := mw.CreateFormFile("upload", "data.txt")
+	_, _ = fw.Write([]byte("file 1 content"))
+	_ = mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body.Bytes()))
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	rd := inspect(t, r, req)
+
+	// Only the password part changes, a short value mustn't be replaced everywhere it happens to appear
+	expected := strings.Replace(body.String(), "\r\n\r\n1\r\n", "\r\n\r\n"+Marker+"\r\n", 1)
+	if rd.Body != expected {
+		t.Errorf("expected only the password part to be redacted, got:\n%s", rd.Body)
+	}
+
+	if rd.Form.Fields["password"][0] != Marker || rd.Form.Fields["user"][0] != "a1b1" {
+		t.Errorf("unexpected form fields: %v", rd.Form.Fields)
+	}
+}
+
+func TestRedactJSONPathsUnparsed(t *testing.T) {
+	r, _ := New(nil, []string{"password"}, nil)
+
+	body := `{"user":"bob","password":"hunter2","padding":"` + strings.Repeat("x", 100) + `"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Truncated bodies aren't parsed, so the paths can't be followed
+	t.Setenv("TMPDIR", t.TempDir())
+
+	rd := httputil.NewRequestDetailsWithOptions(req, httputil.Options{ReadBody: true, MaxBodySize: 50})
+	r.Apply(&rd)
+
+	if !rd.BodyTruncated || rd.Body != Marker {
+		t.Errorf("expected truncated JSON body to be replaced, got %s", rd.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"password":"hunter2",`))
+	req.Header.Set("Content-Type", "application/json")
+
+	if rd = inspect(t, r, req); rd.Body != Marker {
+		t.Errorf("expected invalid JSON body to be replaced, got %s", rd.Body)
+	}
+
+	// Bodies which aren't JSON are left for the patterns
+	req = httptest.NewRequest(http.MethodPost, "/notes", strings.NewReader("just text"))
+	req.Header.Set("Content-Type", "text/plain")
+
+	if rd = inspect(t, r, req); rd.Body != "just text" {
+		t.Errorf("expected plain text body to be unchanged, got %s", rd.Body)
+	}
+}
+
+func TestRedactCookie(t *testing.T) {
+	r, _ := New(nil, nil, []string{`acct-\d+`})
+
+	for name, want := range map[string]string{
+		"JSESSIONID": Marker, "connect.sid": Marker, "auth_token": Marker, "session": Marker, "ARRAffinity": "abc",
+	} {
+		if got := r.Cookie(name, "abc"); got != want {
+			t.Errorf("expected cookie %s to be %s, got %s", name, want, got)
+		}
+	}
+
+	if got := r.Cookie("route", "acct-42.node1"); got != "[REDACTED].node1" {
+		t.Errorf("expected patterns to be applied to cookie values, got %s", got)
+	}
+}
+
+func TestRedactURI(t *testing.T) {
+	r, _ := New(nil, nil, []string{`acct-\d+`})
+
+	got := r.URI("/users/acct-42?sig=abc&page=1")
+	if got != "/users/[REDACTED]?sig=%5BREDACTED%5D&page=1" {
+		t.Errorf("unexpected redacted URI: %s", got)
+	}
+}